@@ -0,0 +1,151 @@
+package service_decorators
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrorAdaptiveConcurrencyDecoratorConfig occurred when the configurations are invalid
+var ErrorAdaptiveConcurrencyDecoratorConfig = errors.New("adaptive concurrency configuration is wrong")
+
+// ErrorBeyondAdaptiveConcurrencyLimit occurred when the in-flight requests reach the adaptive limit
+var ErrorBeyondAdaptiveConcurrencyLimit = errors.New("the concurrency is beyond the adaptive limit")
+
+// AdaptiveConcurrencyDecoratorConfig includes the settings of AdaptiveConcurrencyDecorator
+type AdaptiveConcurrencyDecoratorConfig struct {
+	algorithm ConcurrencyLimitAlgorithm
+
+	// if DropChecker is set, it decides whether the error is caused by the overload,
+	// the other errors are not taken as the samples
+	dropChecker func(err error) bool
+
+	// if FallbackFunction is defined,
+	// it would be called when the concurrency is beyond the limit
+	fallbackFunction ServiceFallbackFunc
+}
+
+// AdaptiveConcurrencyDecorator limits the concurrency with the limit found by
+// ConcurrencyLimitAlgorithm (AIMDLimit, VegasLimit or GradientLimit) from the observed latency,
+// instead of the fixed max concurrency of CircuitBreakDecorator.
+type AdaptiveConcurrencyDecorator struct {
+	config   *AdaptiveConcurrencyDecoratorConfig
+	lock     sync.Mutex
+	inFlight int
+}
+
+// CreateAdaptiveConcurrencyDecoratorConfig is the helper method of creating AdaptiveConcurrencyDecorator.
+// The other settings can be defined by WithXX method chain
+func CreateAdaptiveConcurrencyDecoratorConfig(
+	algorithm ConcurrencyLimitAlgorithm) *AdaptiveConcurrencyDecoratorConfig {
+	return &AdaptiveConcurrencyDecoratorConfig{
+		algorithm:   algorithm,
+		dropChecker: isDroppedByDefault,
+	}
+}
+
+// isDroppedByDefault regards the timeout errors as the drops
+func isDroppedByDefault(err error) bool {
+	return errors.Is(err, ErrorCircuitBreakTimeout) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// WithDropChecker sets the function to decide whether the error is caused by the overload.
+// By default, the timeout errors (ErrorCircuitBreakTimeout and context.DeadlineExceeded) are the drops.
+func (config *AdaptiveConcurrencyDecoratorConfig) WithDropChecker(
+	dropChecker func(err error) bool) *AdaptiveConcurrencyDecoratorConfig {
+	config.dropChecker = dropChecker
+	return config
+}
+
+// WithFallbackFunction sets the fallback method for the error of beyonding the limit
+func (config *AdaptiveConcurrencyDecoratorConfig) WithFallbackFunction(
+	fallbackFn ServiceFallbackFunc) *AdaptiveConcurrencyDecoratorConfig {
+	config.fallbackFunction = fallbackFn
+	return config
+}
+
+// Build will create AdaptiveConcurrencyDecorator with the settings defined by WithXX method chain.
+// The prebuilt algorithms require minLimit <= initialLimit <= maxLimit.
+func (config *AdaptiveConcurrencyDecoratorConfig) Build() (*AdaptiveConcurrencyDecorator, error) {
+	if config.algorithm == nil || config.dropChecker == nil {
+		return nil, ErrorAdaptiveConcurrencyDecoratorConfig
+	}
+	if algorithm, ok := config.algorithm.(validatedConcurrencyLimit); ok && !algorithm.isValid() {
+		return nil, ErrorAdaptiveConcurrencyDecoratorConfig
+	}
+	return &AdaptiveConcurrencyDecorator{config: config}, nil
+}
+
+// Limit is to get the current concurrency limit
+func (dec *AdaptiveConcurrencyDecorator) Limit() int {
+	dec.lock.Lock()
+	defer dec.lock.Unlock()
+	return dec.config.algorithm.Limit()
+}
+
+// InFlight is to get the number of the in-flight requests
+func (dec *AdaptiveConcurrencyDecorator) InFlight() int {
+	dec.lock.Lock()
+	defer dec.lock.Unlock()
+	return dec.inFlight
+}
+
+// acquire is to take a slot of the concurrency,
+// it returns the number of the in-flight requests including the new one
+func (dec *AdaptiveConcurrencyDecorator) acquire() (int, bool) {
+	dec.lock.Lock()
+	defer dec.lock.Unlock()
+	if dec.inFlight >= dec.config.algorithm.Limit() {
+		return 0, false
+	}
+	dec.inFlight++
+	return dec.inFlight, true
+}
+
+// release is to return the slot and update the limit with the sample
+func (dec *AdaptiveConcurrencyDecorator) release(rtt time.Duration, inFlight int,
+	dropped bool, isSample bool) {
+	dec.lock.Lock()
+	defer dec.lock.Unlock()
+	dec.inFlight--
+	if isSample {
+		dec.config.algorithm.Update(rtt, inFlight, dropped)
+	}
+}
+
+// Decorate is to add the adaptive concurrency control logic to the function
+func (dec *AdaptiveConcurrencyDecorator) Decorate(innerFn ServiceFunc) ServiceFunc {
+	return ToServiceFunc(dec.DecorateContext(ToContextServiceFunc(innerFn)))
+}
+
+// DecorateContext is to add the adaptive concurrency control logic to the context-aware function.
+// The successful requests and the dropped requests are taken as the samples,
+// the requests canceled by the caller are ignored.
+// The slot is also released when the inner function panics, and the panic is taken as a drop.
+func (dec *AdaptiveConcurrencyDecorator) DecorateContext(innerFn ContextServiceFunc) ContextServiceFunc {
+	return func(ctx context.Context, req Request) (resp Response, err error) {
+		inFlight, ok := dec.acquire()
+		if !ok {
+			if dec.config.fallbackFunction != nil {
+				return dec.config.fallbackFunction(req, ErrorBeyondAdaptiveConcurrencyLimit)
+			}
+			return nil, ErrorBeyondAdaptiveConcurrencyLimit
+		}
+		start := time.Now()
+		isReturned := false
+		defer func() {
+			rtt := time.Since(start)
+			if !isReturned {
+				dec.release(rtt, inFlight, true, true)
+				return
+			}
+			dropped := err != nil && dec.config.dropChecker(err)
+			isSample := (err == nil || dropped) && ctx.Err() != context.Canceled
+			dec.release(rtt, inFlight, dropped, isSample)
+		}()
+		resp, err = innerFn(ctx, req)
+		isReturned = true
+		return resp, err
+	}
+}
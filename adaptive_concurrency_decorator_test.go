@@ -0,0 +1,159 @@
+package service_decorators
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveConcurrencyDecoratorRejectsBeyondLimit(t *testing.T) {
+	dec, err := CreateAdaptiveConcurrencyDecoratorConfig(CreateAIMDLimit(2, 1, 10)).Build()
+	checkErr(err, t)
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		started <- struct{}{}
+		<-release
+		return req, nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			decFn(1)
+		}()
+	}
+	<-started
+	<-started
+	if inFlight := dec.InFlight(); inFlight != 2 {
+		t.Errorf("The in-flight requests are expected to be 2, but the actual is %d", inFlight)
+	}
+	if _, err := decFn(1); err != ErrorBeyondAdaptiveConcurrencyLimit {
+		t.Errorf("ErrorBeyondAdaptiveConcurrencyLimit is expected, but the actual is %v", err)
+	}
+	close(release)
+	wg.Wait()
+	if inFlight, limit := dec.InFlight(), dec.Limit(); inFlight != 0 || limit < 3 {
+		t.Errorf("Expected in-flight 0 and the increased limit, but the actual is %d and %d",
+			inFlight, limit)
+	}
+}
+
+func TestAdaptiveConcurrencyDecoratorWithFallback(t *testing.T) {
+	dec, err := CreateAdaptiveConcurrencyDecoratorConfig(CreateAIMDLimit(1, 1, 1)).
+		WithFallbackFunction(func(req Request, err error) (Response, error) {
+			return "fallback", nil
+		}).Build()
+	checkErr(err, t)
+	var decFn ServiceFunc
+	decFn = dec.Decorate(func(req Request) (Response, error) {
+		if req == "outer" {
+			// the outer request holds the only slot
+			return decFn("inner")
+		}
+		return req, nil
+	})
+	resp, err := decFn("outer")
+	checkErr(err, t)
+	if resp != "fallback" {
+		t.Errorf("The fallback response is expected, but the actual is %v", resp)
+	}
+}
+
+func TestAdaptiveConcurrencyDecoratorSamples(t *testing.T) {
+	errUnexpected := errors.New("unexpected error")
+	dec, err := CreateAdaptiveConcurrencyDecoratorConfig(CreateAIMDLimit(2, 1, 10)).
+		WithDropChecker(func(err error) bool {
+			return err == ErrorCircuitBreakTimeout
+		}).Build()
+	checkErr(err, t)
+	decFn := dec.DecorateContext(func(ctx context.Context, req Request) (Response, error) {
+		return nil, req.(error)
+	})
+	decFn(context.Background(), errUnexpected)
+	if limit := dec.Limit(); limit != 2 {
+		t.Errorf("The unexpected error is not expected to change the limit, but the actual is %d", limit)
+	}
+	decFn(context.Background(), ErrorCircuitBreakTimeout)
+	if limit := dec.Limit(); limit != 1 {
+		t.Errorf("The dropped request is expected to decrease the limit, but the actual is %d", limit)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	decFn(ctx, ErrorCircuitBreakTimeout)
+	if limit := dec.Limit(); limit != 1 {
+		t.Errorf("The canceled request is not expected to change the limit, but the actual is %d", limit)
+	}
+}
+
+func TestAdaptiveConcurrencyDecoratorWithGradientUnderLoad(t *testing.T) {
+	dec, err := CreateAdaptiveConcurrencyDecoratorConfig(CreateGradientLimit(5, 1, 50)).Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		time.Sleep(time.Millisecond)
+		return req, nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				decFn(j)
+			}
+		}()
+	}
+	wg.Wait()
+	if limit := dec.Limit(); limit < 1 || limit > 50 {
+		t.Errorf("The limit is expected to be in [1, 50], but the actual is %d", limit)
+	}
+	if inFlight := dec.InFlight(); inFlight != 0 {
+		t.Errorf("The in-flight requests are expected to be 0, but the actual is %d", inFlight)
+	}
+}
+
+func TestAdaptiveConcurrencyDecoratorWithoutAlgorithm(t *testing.T) {
+	_, err := CreateAdaptiveConcurrencyDecoratorConfig(nil).Build()
+	if err != ErrorAdaptiveConcurrencyDecoratorConfig {
+		t.Errorf("ErrorAdaptiveConcurrencyDecoratorConfig is expected, but the actual is %v", err)
+	}
+}
+
+func TestAdaptiveConcurrencyDecoratorWithInvalidLimitRange(t *testing.T) {
+	invalidAlgorithms := []ConcurrencyLimitAlgorithm{
+		CreateAIMDLimit(20, 0, 0),
+		CreateVegasLimit(20, 1, 10),
+		CreateGradientLimit(5, 10, 100),
+	}
+	for _, algorithm := range invalidAlgorithms {
+		if _, err := CreateAdaptiveConcurrencyDecoratorConfig(algorithm).
+			Build(); err != ErrorAdaptiveConcurrencyDecoratorConfig {
+			t.Errorf("ErrorAdaptiveConcurrencyDecoratorConfig is expected for %+v, but the actual is %v",
+				algorithm, err)
+		}
+	}
+}
+
+func TestAdaptiveConcurrencyDecoratorReleasesOnPanic(t *testing.T) {
+	dec, err := CreateAdaptiveConcurrencyDecoratorConfig(CreateAIMDLimit(10, 1, 100)).Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		panic("service panicked")
+	})
+	for i := 0; i < 20; i++ {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Error("The panic is expected to be propagated.")
+				}
+			}()
+			decFn(i)
+		}()
+	}
+	checkCnt(dec.InFlight(), 0, t)
+	// the panics are taken as the drops
+	checkCnt(dec.Limit(), 1, t)
+}
@@ -1,44 +1,364 @@
 package service_decorators
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// ErrorCircuitBreakerInnerPanic is counted as the error of the request when the decorated function panics
+var ErrorCircuitBreakerInnerPanic = errors.New("the decorated function panics")
+
 // ErrorDistinguisherFn is to decide if the error should be counted.
 type ErrorDistinguisherFn func(err error) bool
 
+// CircuitBreakerState is the state of AdvancedCircuitBreakDecorator
+type CircuitBreakerState int
+
+const (
+	// CircuitBreakerClosed passes the requests to the backend and counts the errors
+	CircuitBreakerClosed CircuitBreakerState = iota
+	// CircuitBreakerOpen processes the requests by the fallback function
+	CircuitBreakerOpen
+	// CircuitBreakerHalfOpen passes the limited trial requests to the backend
+	// to check if the backend is recovered
+	CircuitBreakerHalfOpen
+)
+
+func (state CircuitBreakerState) String() string {
+	switch state {
+	case CircuitBreakerClosed:
+		return "CLOSED"
+	case CircuitBreakerOpen:
+		return "OPEN"
+	case CircuitBreakerHalfOpen:
+		return "HALF_OPEN"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// The reasons of the state transitions
+const (
+	ReasonContinuousErrors = "the continuous errors reached the threshold"
+	ReasonFailureRate      = "the failure rate reached the threshold"
+	ReasonSlowCallRate     = "the slow call rate reached the threshold"
+	ReasonRetryInterval    = "the backend retry interval elapsed"
+	ReasonTrialFailed      = "the trial request failed"
+	ReasonTrialSlow        = "the trial request was slow"
+	ReasonTrialsSucceeded  = "the trial requests succeeded"
+)
+
+// CircuitBreakerStateTransition is the state transition of AdvancedCircuitBreakDecorator
+type CircuitBreakerStateTransition struct {
+	Key    string // the key of KeyedCircuitBreakDecorator, "" for AdvancedCircuitBreakDecorator
+	From   CircuitBreakerState
+	To     CircuitBreakerState
+	Reason string
+	Time   time.Time
+}
+
+// CircuitBreakerStateListener is notified of the state transitions.
+// It is called synchronously by the request causing the transition, so it should return quickly.
+type CircuitBreakerStateListener func(transition CircuitBreakerStateTransition)
+
+// CircuitBreakerMetrics is the snapshot of the metrics of AdvancedCircuitBreakDecorator
+type CircuitBreakerMetrics struct {
+	State              CircuitBreakerState
+	NumOfSuccesses     int64     // the requests processed by the backend successfully
+	NumOfFailures      int64     // the requests failed with the counted errors
+	NumOfRejections    int64     // the requests processed by the fallback function
+	LastTransitionTime time.Time // zero if the state has never changed
+}
+
 // AdvancedCircuitBreakDecorator is the advanced CircuitBeakDecorator,
 // which can support complex circuit break strategy.
 // For circuitBreak states transition, please refer to
 // https://github.com/easierway/service_decorators/blob/master/doc_pics/circuit_breaker_states_transtion.png
 //
-// 1. Failure frequency will cause circuit breaker state to open state
+// 1. In CLOSED state, failure frequency will cause circuit breaker state to OPEN state
 // -- Failure frequency involves ErrorCount and ResetIntervalOfErrorCount
-//    They are used to count the errors occurred in the time window.
-// -- ErrorDistinguisher is to decide what kind of errors would be counted with ErrorCounter.
-// -- ErrorCounter is to count the continuous occurred errors in the time window
+// They are used to count the errors occurred in the time window.
+// -- ErrorDistinguisher is to decide what kind of errors would be counted.
+// -- ErrorCount is to count the continuous occurred errors in the time window
 // -- ResetIntervalOfErrorCount is the interval of resetting error counter to 0
-// 2. When circuit breaker is in open state, the requests will be processed
-//    by fallback function (FallbackFn)
-// 3. BackendRetryInterval is to check if the backend service is health/recovered.
-//    The time interval since the last time of backend service invoked is beyond BackendRetryInterval
-//    even the circuit breaker is in close state, current request will be passed to backend service.
-//    If the request be processed successfully or no counted errors happen (decided by ErrorDistinguisher)
-//    the circuit break will switch to close state
+// 2. When circuit breaker is in OPEN state, the requests will be processed
+// by fallback function (FallbackFn) with the last counted error
+// 3. BackendRetryInterval is the duration of OPEN state, after that the circuit breaker switches to
+// HALF_OPEN state to check if the backend service is health/recovered.
+// In HALF_OPEN state, at most HalfOpenMaxProbes requests are passed to backend service concurrently,
+// the others are processed by fallback function.
+// When HalfOpenSuccessThreshold requests are processed successfully, the circuit break will switch to
+// CLOSED state, and any counted error (decided by ErrorDistinguisher) will switch it back to OPEN state.
+//
+// 4. With WithSlidingWindow, the circuit breaker also switches to OPEN state when the failure rate
+// or the slow call rate of the calls in the sliding window reaches the threshold,
+// which is evaluated only when the window has the minimum number of calls.
+//
+// The settings should not be changed after the decorator is used.
+// The state is protected by the lock, so the decorator is safe to be used by the concurrent requests.
 type AdvancedCircuitBreakDecorator struct {
-	ErrorCounter       int64
-	ErrorDistinguisher ErrorDistinguisherFn
+	// Deprecated: ErrorCounter is the snapshot of the continuous counted errors, it should be read
+	// with atomic.LoadInt64. Use ErrorCount instead.
+	ErrorCounter int64
+	// Deprecated: LastError is the snapshot of the last counted error,
+	// which is not safe to be read by the concurrent requests. Use LastCountedError instead.
+	LastError error
 
+	ErrorDistinguisher          ErrorDistinguisherFn
 	ErrorFrequencyThreshold     int64
-	LastError                   error
 	ResetIntervalOfErrorCounter time.Duration
 	BackendRetryInterval        time.Duration
-	lastErrorOccuredTime        time.Time
-	lastBackendInvokingTime     time.Time
+	HalfOpenMaxProbes           int
+	HalfOpenSuccessThreshold    int
 	FallbackFn                  ServiceFallbackFunc
+
+	slidingWindow *slidingWindowSettings
+	breaker       *advancedCircuitBreaker
+
+	listenersLock sync.RWMutex
+	listeners     []CircuitBreakerStateListener
+
+	overrides overrideSwitch
+}
+
+// slidingWindowSettings decides whether to open the circuit breaker
+// by the failure rate and the slow call rate
+type slidingWindowSettings struct {
+	windowType            SlidingWindowType
+	windowSize            int
+	minimumNumberOfCalls  int
+	failureRateThreshold  float64
+	slowCallRateThreshold float64
+	slowCallDuration      time.Duration
+}
+
+func (settings *slidingWindowSettings) isSlow(duration time.Duration) bool {
+	return settings != nil && settings.slowCallRateThreshold > 0 && duration > settings.slowCallDuration
 }
 
+// checkThresholds is to check if the failure rate or the slow call rate reaches the threshold,
+// it returns the reason of opening the circuit breaker, or "" when both are below the thresholds.
+func (settings *slidingWindowSettings) checkThresholds(stats windowStats) string {
+	if stats.calls == 0 || stats.calls < settings.minimumNumberOfCalls {
+		return ""
+	}
+	calls := float64(stats.calls)
+	if settings.failureRateThreshold > 0 &&
+		float64(stats.failures)*100 >= settings.failureRateThreshold*calls {
+		return ReasonFailureRate
+	}
+	if settings.slowCallRateThreshold > 0 &&
+		float64(stats.slowCalls)*100 >= settings.slowCallRateThreshold*calls {
+		return ReasonSlowCallRate
+	}
+	return ""
+}
+
+// advancedCircuitBreaker is the state machine of the circuit breaker.
+// The generation is increased for each state transition, so the results of the requests
+// passed in the previous states are ignored.
+type advancedCircuitBreaker struct {
+	lock                  sync.Mutex
+	key                   string
+	state                 CircuitBreakerState
+	generation            uint64
+	errorCount            int64
+	lastError             error
+	lastErrorOccurredTime time.Time
+	openedTime            time.Time
+	probesInFlight        int
+	probeSuccesses        int
+	window                slidingWindow
+	metrics               CircuitBreakerMetrics
+	// the transitions to be notified after unlocking
+	transitions []CircuitBreakerStateTransition
+}
+
+func createAdvancedCircuitBreaker(key string, settings *slidingWindowSettings) *advancedCircuitBreaker {
+	breaker := &advancedCircuitBreaker{key: key, state: CircuitBreakerClosed}
+	if settings != nil {
+		breaker.window = createSlidingWindow(settings.windowType, settings.windowSize)
+	}
+	return breaker
+}
+
+func (breaker *advancedCircuitBreaker) transitTo(state CircuitBreakerState, now time.Time, reason string) {
+	breaker.transitions = append(breaker.transitions, CircuitBreakerStateTransition{
+		Key:    breaker.key,
+		From:   breaker.state,
+		To:     state,
+		Reason: reason,
+		Time:   now,
+	})
+	breaker.metrics.LastTransitionTime = now
+	breaker.state = state
+	breaker.generation++
+	breaker.probesInFlight = 0
+	breaker.probeSuccesses = 0
+	switch state {
+	case CircuitBreakerOpen:
+		breaker.openedTime = now
+	case CircuitBreakerClosed:
+		breaker.errorCount = 0
+		if breaker.window != nil {
+			breaker.window.reset()
+		}
+	}
+}
+
+// allow is to decide whether the request at the time now can be passed to the backend.
+// It returns the generation of the current state to report the result,
+// and the last counted error when the request is rejected.
+func (breaker *advancedCircuitBreaker) allow(now time.Time,
+	dec *AdvancedCircuitBreakDecorator) (uint64, bool, error) {
+	breaker.lock.Lock()
+	defer breaker.unlockAndNotify(dec)
+	if breaker.state == CircuitBreakerOpen &&
+		now.Sub(breaker.openedTime) >= dec.BackendRetryInterval {
+		breaker.transitTo(CircuitBreakerHalfOpen, now, ReasonRetryInterval)
+	}
+	switch breaker.state {
+	case CircuitBreakerOpen:
+		breaker.metrics.NumOfRejections++
+		return breaker.generation, false, breaker.lastError
+	case CircuitBreakerHalfOpen:
+		if breaker.probesInFlight >= dec.halfOpenMaxProbes() {
+			breaker.metrics.NumOfRejections++
+			return breaker.generation, false, breaker.lastError
+		}
+		breaker.probesInFlight++
+	default:
+		if now.Sub(breaker.lastErrorOccurredTime) > dec.ResetIntervalOfErrorCounter {
+			breaker.errorCount = 0
+		}
+	}
+	return breaker.generation, true, nil
+}
+
+// onResult is to update the state with the result of the request passed at the generation,
+// duration is the time spent by the request.
+func (breaker *advancedCircuitBreaker) onResult(generation uint64, now time.Time,
+	duration time.Duration, err error, dec *AdvancedCircuitBreakDecorator) {
+	isCounted := err == ErrorCircuitBreakerInnerPanic || (err != nil && dec.ErrorDistinguisher(err))
+	isSlow := dec.slidingWindow.isSlow(duration)
+	breaker.lock.Lock()
+	defer breaker.unlockAndNotify(dec)
+	if isCounted {
+		breaker.lastError = err
+		breaker.metrics.NumOfFailures++
+	} else if err == nil {
+		breaker.metrics.NumOfSuccesses++
+	}
+	if generation != breaker.generation {
+		return
+	}
+	switch breaker.state {
+	case CircuitBreakerHalfOpen:
+		breaker.probesInFlight--
+		if isCounted {
+			breaker.transitTo(CircuitBreakerOpen, now, ReasonTrialFailed)
+		} else if isSlow {
+			breaker.transitTo(CircuitBreakerOpen, now, ReasonTrialSlow)
+		} else if err == nil {
+			breaker.probeSuccesses++
+			if breaker.probeSuccesses >= dec.HalfOpenSuccessThreshold {
+				breaker.transitTo(CircuitBreakerClosed, now, ReasonTrialsSucceeded)
+			}
+		}
+	case CircuitBreakerClosed:
+		if err != nil && !isCounted {
+			return
+		}
+		if err == nil {
+			breaker.errorCount = 0
+		} else {
+			breaker.errorCount++
+			breaker.lastErrorOccurredTime = now
+		}
+		reason := ""
+		if isCounted && breaker.errorCount >= dec.ErrorFrequencyThreshold &&
+			(breaker.window == nil || dec.ErrorFrequencyThreshold > 0) {
+			reason = ReasonContinuousErrors
+		}
+		if breaker.window != nil {
+			breaker.window.record(now, callOutcome{failed: isCounted, slow: isSlow})
+			if reason == "" {
+				reason = dec.slidingWindow.checkThresholds(breaker.window.stats(now))
+			}
+		}
+		if reason != "" {
+			breaker.transitTo(CircuitBreakerOpen, now, reason)
+		}
+	}
+}
+
+// unlockAndNotify is to release the lock and notify the listeners of the transitions
+func (breaker *advancedCircuitBreaker) unlockAndNotify(dec *AdvancedCircuitBreakDecorator) {
+	if breaker == dec.breaker {
+		// keep the deprecated fields of the decorator working
+		atomic.StoreInt64(&dec.ErrorCounter, breaker.errorCount)
+		dec.LastError = breaker.lastError
+	}
+	transitions := breaker.transitions
+	breaker.transitions = nil
+	breaker.lock.Unlock()
+	if len(transitions) > 0 {
+		dec.notifyStateListeners(transitions)
+	}
+}
+
+// recordOverridden is to count the request processed with the override in the metrics,
+// the state is not changed.
+func (breaker *advancedCircuitBreaker) recordOverridden(isRejected bool, err error,
+	dec *AdvancedCircuitBreakDecorator) {
+	isCounted := err != nil && dec.ErrorDistinguisher(err)
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	switch {
+	case isRejected:
+		breaker.metrics.NumOfRejections++
+	case isCounted:
+		breaker.metrics.NumOfFailures++
+	case err == nil:
+		breaker.metrics.NumOfSuccesses++
+	}
+}
+
+func (breaker *advancedCircuitBreaker) getMetrics() CircuitBreakerMetrics {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	metrics := breaker.metrics
+	metrics.State = breaker.state
+	return metrics
+}
+
+func (breaker *advancedCircuitBreaker) getErrorCount() int64 {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	return breaker.errorCount
+}
+
+func (breaker *advancedCircuitBreaker) getLastError() error {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	return breaker.lastError
+}
+
+// halfOpenMaxProbes is to get the max number of the trial requests,
+// at least one is passed, otherwise the circuit breaker would never leave HALF_OPEN state.
+func (dec *AdvancedCircuitBreakDecorator) halfOpenMaxProbes() int {
+	if dec.HalfOpenMaxProbes < 1 {
+		return 1
+	}
+	return dec.HalfOpenMaxProbes
+}
+
+// CreateAdvancedCircuitBreakDecorator is to create AdvancedCircuitBreakDecorator.
+// One trial request is passed in HALF_OPEN state, and one success closes the circuit breaker,
+// which can be changed by WithHalfOpenProbes.
 func CreateAdvancedCircuitBreakDecorator(
 	errorFrequencyThreshold int64,
 	resetIntervalOfErrorCounter time.Duration,
@@ -50,33 +370,193 @@ func CreateAdvancedCircuitBreakDecorator(
 		ResetIntervalOfErrorCounter: resetIntervalOfErrorCounter,
 		BackendRetryInterval:        backendRetryInterval,
 		ErrorDistinguisher:          errorDistinguisher,
+		HalfOpenMaxProbes:           1,
+		HalfOpenSuccessThreshold:    1,
 		FallbackFn:                  fallbackFn,
-		lastBackendInvokingTime:     time.Now(),
+		breaker:                     createAdvancedCircuitBreaker("", nil),
 	}
 }
 
-func (dec *AdvancedCircuitBreakDecorator) Decorate(innerFn ServiceFunc) ServiceFunc {
-	return func(req Request) (Response, error) {
-		now := time.Now()
-		durErr := now.Sub(dec.lastErrorOccuredTime)
-		durRetry := now.Sub(dec.lastBackendInvokingTime)
-		if durErr > dec.ResetIntervalOfErrorCounter {
-			atomic.StoreInt64(&dec.ErrorCounter, 0)
-		}
-		if durRetry < dec.BackendRetryInterval &&
-			atomic.LoadInt64(&dec.ErrorCounter) >= dec.ErrorFrequencyThreshold {
-			return dec.FallbackFn(req, dec.LastError)
+// WithHalfOpenProbes sets the max number of the concurrent trial requests in HALF_OPEN state,
+// and the number of the successful trial requests to switch to CLOSED state.
+// Both of them are at least 1.
+func (dec *AdvancedCircuitBreakDecorator) WithHalfOpenProbes(maxProbes int,
+	successThreshold int) *AdvancedCircuitBreakDecorator {
+	if maxProbes < 1 {
+		maxProbes = 1
+	}
+	if successThreshold < 1 {
+		successThreshold = 1
+	}
+	dec.HalfOpenMaxProbes = maxProbes
+	dec.HalfOpenSuccessThreshold = successThreshold
+	return dec
+}
+
+// WithSlidingWindow is to open the circuit breaker by the failure rate and the slow call rate
+// of the calls in the sliding window (see WithFailureRateThreshold and WithSlowCallRateThreshold).
+// windowType: CountBasedSlidingWindow or TimeBasedSlidingWindow
+// windowSize: the number of the calls for CountBasedSlidingWindow, or the seconds for TimeBasedSlidingWindow
+// minimumNumberOfCalls: the rates are not evaluated until the window has the calls
+// The errors not counted by ErrorDistinguisher are not recorded in the window.
+// With the sliding window, ErrorFrequencyThreshold <= 0 disables the check of the continuous errors.
+func (dec *AdvancedCircuitBreakDecorator) WithSlidingWindow(windowType SlidingWindowType,
+	windowSize int, minimumNumberOfCalls int) *AdvancedCircuitBreakDecorator {
+	if windowSize < 1 {
+		windowSize = 1
+	}
+	settings := dec.slidingWindowSettings()
+	settings.windowType = windowType
+	settings.windowSize = windowSize
+	settings.minimumNumberOfCalls = minimumNumberOfCalls
+	dec.breaker = createAdvancedCircuitBreaker("", settings)
+	return dec
+}
+
+// WithFailureRateThreshold sets the percentage of the counted errors to open the circuit breaker.
+// The count based sliding window of 100 calls is used if WithSlidingWindow is not set.
+func (dec *AdvancedCircuitBreakDecorator) WithFailureRateThreshold(
+	percentage float64) *AdvancedCircuitBreakDecorator {
+	dec.slidingWindowSettings().failureRateThreshold = percentage
+	return dec
+}
+
+// WithSlowCallRateThreshold sets the percentage of the calls slower than slowCallDuration
+// to open the circuit breaker. The slow trial request in HALF_OPEN state switches it back to OPEN state.
+// The count based sliding window of 100 calls is used if WithSlidingWindow is not set.
+func (dec *AdvancedCircuitBreakDecorator) WithSlowCallRateThreshold(percentage float64,
+	slowCallDuration time.Duration) *AdvancedCircuitBreakDecorator {
+	settings := dec.slidingWindowSettings()
+	settings.slowCallRateThreshold = percentage
+	settings.slowCallDuration = slowCallDuration
+	return dec
+}
+
+// slidingWindowSettings is to get the sliding window settings, the default window is created if absent
+func (dec *AdvancedCircuitBreakDecorator) slidingWindowSettings() *slidingWindowSettings {
+	if dec.slidingWindow == nil {
+		dec.slidingWindow = &slidingWindowSettings{
+			windowType:           CountBasedSlidingWindow,
+			windowSize:           100,
+			minimumNumberOfCalls: 100,
 		}
-		ret, err := innerFn(req)
-		dec.lastBackendInvokingTime = now
-		if err == nil {
-			atomic.StoreInt64(&dec.ErrorCounter, 0)
-		} else {
-			if dec.ErrorDistinguisher(err) {
-				atomic.AddInt64(&dec.ErrorCounter, 1)
-				dec.lastErrorOccuredTime = now
-			}
+		dec.breaker = createAdvancedCircuitBreaker("", dec.slidingWindow)
+	}
+	return dec.slidingWindow
+}
+
+// SetOverride is to override the circuit breaker manually, NoOverride is to cancel the override.
+// With ForceOpen, the requests are processed by the fallback function with ErrorCircuitBreakerForcedOpen.
+// With ForceClosed, the requests are passed to the backend and only counted in the metrics.
+// With Disabled, the requests are passed to the backend as if the circuit breaker doesn't exist.
+func (dec *AdvancedCircuitBreakDecorator) SetOverride(override CircuitBreakerOverride) {
+	dec.overrides.set(override)
+}
+
+// Override is to get the current override
+func (dec *AdvancedCircuitBreakDecorator) Override() CircuitBreakerOverride {
+	return dec.overrides.get()
+}
+
+// WithOverrideConfigStorage is to read the override (CircuitBreakerOverrideConfig) from the storage,
+// and refresh it periodically. The override in the storage is applied when it is changed,
+// and the invalid configurations are ignored.
+// configStore: the storage is used to store the override configuration
+// configName: the config name in the storage
+// refreshInterval: the interval of reloading the configuration, 0 means no reloading
+func (dec *AdvancedCircuitBreakDecorator) WithOverrideConfigStorage(configStorage ConfigStorage,
+	configName string, refreshInterval time.Duration) *AdvancedCircuitBreakDecorator {
+	dec.overrides.watch(configStorage, configName, refreshInterval)
+	return dec
+}
+
+// AddStateListener is to register the listener of the state transitions
+func (dec *AdvancedCircuitBreakDecorator) AddStateListener(
+	listener CircuitBreakerStateListener) *AdvancedCircuitBreakDecorator {
+	dec.listenersLock.Lock()
+	defer dec.listenersLock.Unlock()
+	dec.listeners = append(dec.listeners, listener)
+	return dec
+}
+
+func (dec *AdvancedCircuitBreakDecorator) notifyStateListeners(transitions []CircuitBreakerStateTransition) {
+	dec.listenersLock.RLock()
+	listeners := dec.listeners
+	dec.listenersLock.RUnlock()
+	for _, transition := range transitions {
+		for _, listener := range listeners {
+			listener(transition)
 		}
+	}
+}
+
+// State is to get the current state.
+// The OPEN state switches to HALF_OPEN state when the next request arrives after BackendRetryInterval.
+func (dec *AdvancedCircuitBreakDecorator) State() CircuitBreakerState {
+	return dec.breaker.getMetrics().State
+}
+
+// Metrics is to get the snapshot of the metrics
+func (dec *AdvancedCircuitBreakDecorator) Metrics() CircuitBreakerMetrics {
+	return dec.breaker.getMetrics()
+}
+
+// ErrorCount is to get the number of the continuous counted errors in CLOSED state
+func (dec *AdvancedCircuitBreakDecorator) ErrorCount() int64 {
+	return dec.breaker.getErrorCount()
+}
+
+// LastCountedError is to get the last counted error
+func (dec *AdvancedCircuitBreakDecorator) LastCountedError() error {
+	return dec.breaker.getLastError()
+}
+
+// isStatefulCircuitBreaker lets the chain rules check the order of the decorator
+func (dec *AdvancedCircuitBreakDecorator) isStatefulCircuitBreaker() {}
+
+// Decorate is to add the circuit break logic to the function
+func (dec *AdvancedCircuitBreakDecorator) Decorate(innerFn ServiceFunc) ServiceFunc {
+	return ToServiceFunc(dec.DecorateContext(ToContextServiceFunc(innerFn)))
+}
+
+// DecorateContext is to add the circuit break logic to the context-aware function
+func (dec *AdvancedCircuitBreakDecorator) DecorateContext(innerFn ContextServiceFunc) ContextServiceFunc {
+	return func(ctx context.Context, req Request) (Response, error) {
+		return dec.invoke(ctx, req, innerFn, dec.breaker, dec.FallbackFn)
+	}
+}
+
+// invoke is to process the request with the circuit breaker and the fallback function
+func (dec *AdvancedCircuitBreakDecorator) invoke(ctx context.Context, req Request,
+	innerFn ContextServiceFunc, breaker *advancedCircuitBreaker,
+	fallbackFn ServiceFallbackFunc) (Response, error) {
+	switch dec.overrides.get() {
+	case Disabled:
+		return innerFn(ctx, req)
+	case ForceOpen:
+		breaker.recordOverridden(true, nil, dec)
+		return fallbackFn(req, ErrorCircuitBreakerForcedOpen)
+	case ForceClosed:
+		ret, err := innerFn(ctx, req)
+		breaker.recordOverridden(false, err, dec)
 		return ret, err
 	}
+	generation, ok, lastErr := breaker.allow(time.Now(), dec)
+	if !ok {
+		return fallbackFn(req, lastErr)
+	}
+	start := time.Now()
+	isReturned := false
+	defer func() {
+		if !isReturned {
+			// release the trial request, the panic is still propagated to the caller
+			now := time.Now()
+			breaker.onResult(generation, now, now.Sub(start), ErrorCircuitBreakerInnerPanic, dec)
+		}
+	}()
+	ret, err := innerFn(ctx, req)
+	isReturned = true
+	now := time.Now()
+	breaker.onResult(generation, now, now.Sub(start), err, dec)
+	return ret, err
 }
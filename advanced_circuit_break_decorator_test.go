@@ -2,6 +2,9 @@ package service_decorators
 
 import (
 	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -151,15 +154,13 @@ func TestRetryBackendFnAndRecovered(t *testing.T) {
 }
 
 func TestInConcurrentEnv(t *testing.T) {
-	cntFallback := 0
-	cntBackend := 0
+	var cntFallback, cntBackend int32
 	fallbackFn := func(req Request, lastErr error) (Response, error) {
-		cntFallback++
+		atomic.AddInt32(&cntFallback, 1)
 		return nil, nil
 	}
 	serviceFn := func(req Request) (Response, error) {
-		cntBackend++
-		if cntBackend > 3 {
+		if atomic.AddInt32(&cntBackend, 1) > 3 {
 			return nil, nil
 		}
 		return nil, errors.New("error")
@@ -177,18 +178,377 @@ func TestInConcurrentEnv(t *testing.T) {
 				time.Sleep(time.Millisecond * 200)
 			}
 			decFn("input")
-			t.Logf("errCnt:%d fallbackCnt:%d\n", dec.ErrorCounter, cntFallback)
+			t.Logf("errCnt:%d fallbackCnt:%d\n", dec.ErrorCount(), atomic.LoadInt32(&cntFallback))
 		}(i)
 	}
 	time.Sleep(time.Millisecond * 300)
 	expectedFallbackTimes := 2
 	expectedBackendTimes := 6
-	if cntFallback != expectedFallbackTimes {
+	if cnt := atomic.LoadInt32(&cntFallback); cnt != int32(expectedFallbackTimes) {
 		t.Errorf("expected times is %d, but the actual times is %d\n",
-			expectedFallbackTimes, cntFallback)
+			expectedFallbackTimes, cnt)
 	}
-	if cntBackend != expectedBackendTimes {
+	if cnt := atomic.LoadInt32(&cntBackend); cnt != int32(expectedBackendTimes) {
 		t.Errorf("expected times is %d, but the actual times is %d\n",
-			expectedBackendTimes, cntBackend)
+			expectedBackendTimes, cnt)
+	}
+}
+
+func TestFallbackWithLastError(t *testing.T) {
+	errBackend := errors.New("backend error")
+	var lastErrs []error
+	dec := CreateAdvancedCircuitBreakDecorator(2, time.Second, time.Second,
+		func(err error) bool { return err != ErrorConnection },
+		func(req Request, lastErr error) (Response, error) {
+			lastErrs = append(lastErrs, lastErr)
+			return nil, lastErr
+		})
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		return nil, req.(error)
+	})
+	decFn(errBackend)
+	decFn(ErrorConnection)
+	decFn(errBackend)
+	if _, err := decFn(ErrorConnection); err != errBackend {
+		t.Errorf("The last counted error is expected, but the actual is %v", err)
+	}
+	if len(lastErrs) != 1 || lastErrs[0] != errBackend || dec.LastCountedError() != errBackend {
+		t.Errorf("The fallback is expected to get the last counted error, but the actual is %v", lastErrs)
+	}
+	if cnt := dec.ErrorCount(); cnt != 2 {
+		t.Errorf("The error count is expected to be 2, but the actual is %d", cnt)
+	}
+	// the deprecated fields are kept working
+	if dec.LastError != errBackend || atomic.LoadInt64(&dec.ErrorCounter) != 2 {
+		t.Errorf("Unexpected deprecated fields %v %d", dec.LastError, dec.ErrorCounter)
+	}
+}
+
+func TestAdvancedCircuitBreakUnderConcurrentLoad(t *testing.T) {
+	errBackend := errors.New("backend error")
+	var cntFallback, cntBackend, cntNilLastErr int32
+	dec := CreateAdvancedCircuitBreakDecorator(5, time.Millisecond*50, time.Millisecond*5,
+		func(err error) bool { return true },
+		func(req Request, lastErr error) (Response, error) {
+			atomic.AddInt32(&cntFallback, 1)
+			if lastErr != errBackend {
+				atomic.AddInt32(&cntNilLastErr, 1)
+			}
+			return nil, lastErr
+		})
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		if atomic.AddInt32(&cntBackend, 1)%4 == 0 {
+			return req, nil
+		}
+		time.Sleep(time.Microsecond * 100)
+		return nil, errBackend
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				decFn(j)
+				dec.ErrorCount()
+				dec.LastCountedError()
+			}
+		}()
+	}
+	wg.Wait()
+	total := atomic.LoadInt32(&cntFallback) + atomic.LoadInt32(&cntBackend)
+	if total != 5000 {
+		t.Errorf("Each request is expected to be processed once, but the actual total is %d", total)
+	}
+	if cntNilLastErr != 0 {
+		t.Errorf("The fallback is expected to get the backend error, but %d calls got the other", cntNilLastErr)
+	}
+}
+
+func tripAdvancedCircuitBreaker(decFn ServiceFunc, threshold int) {
+	for i := 0; i < threshold; i++ {
+		decFn(errors.New("error"))
+	}
+}
+
+func TestHalfOpenWithLimitedProbes(t *testing.T) {
+	var cntFallback int32
+	dec := CreateAdvancedCircuitBreakDecorator(2, time.Second, time.Millisecond*20,
+		func(err error) bool { return true },
+		func(req Request, lastErr error) (Response, error) {
+			atomic.AddInt32(&cntFallback, 1)
+			return nil, lastErr
+		}).WithHalfOpenProbes(2, 3)
+	release := make(chan struct{})
+	probing := make(chan struct{}, 10)
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		if err, ok := req.(error); ok {
+			return nil, err
+		}
+		probing <- struct{}{}
+		<-release
+		return req, nil
+	})
+	tripAdvancedCircuitBreaker(decFn, 2)
+	time.Sleep(time.Millisecond * 30)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			decFn("probe")
+		}()
+	}
+	<-probing
+	<-probing
+	for atomic.LoadInt32(&cntFallback) != 3 {
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+	if len(probing) != 0 {
+		t.Errorf("Only 2 trial requests are expected, but %d more are passed", len(probing))
+	}
+	// 2 successes are below the success threshold, the next probe closes the circuit breaker
+	if _, err := decFn("probe"); err != nil {
+		t.Error(err)
+	}
+	<-probing
+	for i := 0; i < 5; i++ {
+		decFn("closed")
+		<-probing
+	}
+	checkCnt(int(atomic.LoadInt32(&cntFallback)), 3, t)
+}
+
+func TestHalfOpenReopensOnError(t *testing.T) {
+	cntFallback := 0
+	cntBackend := 0
+	dec := CreateAdvancedCircuitBreakDecorator(2, time.Second, time.Millisecond*20,
+		func(err error) bool { return true },
+		func(req Request, lastErr error) (Response, error) {
+			cntFallback++
+			return nil, lastErr
+		}).WithHalfOpenProbes(1, 2)
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		cntBackend++
+		if err, ok := req.(error); ok {
+			return nil, err
+		}
+		return req, nil
+	})
+	tripAdvancedCircuitBreaker(decFn, 2)
+	time.Sleep(time.Millisecond * 30)
+	decFn("probe")
+	decFn(errors.New("still broken"))
+	decFn("rejected")
+	checkCnt(cntBackend, 4, t)
+	checkCnt(cntFallback, 1, t)
+	time.Sleep(time.Millisecond * 30)
+	decFn("probe")
+	decFn("probe")
+	decFn("closed")
+	checkCnt(cntBackend, 7, t)
+	checkCnt(cntFallback, 1, t)
+}
+
+func TestHalfOpenReleasesProbeOnPanic(t *testing.T) {
+	dec := CreateAdvancedCircuitBreakDecorator(1, time.Second, time.Millisecond*20,
+		func(err error) bool { return err == ErrorConnection },
+		func(req Request, lastErr error) (Response, error) {
+			return nil, lastErr
+		})
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		if req == "panic" {
+			panic("the backend panics")
+		}
+		return nil, ErrorConnection
+	})
+	tripAdvancedCircuitBreaker(decFn, 1)
+	time.Sleep(time.Millisecond * 30)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("The panic is expected to be propagated")
+			}
+		}()
+		decFn("panic")
+	}()
+	// the panicked probe is counted as the failure, so the circuit breaker is open again
+	if state := dec.State(); state != CircuitBreakerOpen {
+		t.Errorf("The state is expected to be OPEN, but the actual is %v", state)
+	}
+	if _, err := decFn("rejected"); err != ErrorCircuitBreakerInnerPanic {
+		t.Errorf("ErrorCircuitBreakerInnerPanic is expected, but the actual is %v", err)
+	}
+	time.Sleep(time.Millisecond * 30)
+	if _, err := decFn("probe"); err != ErrorConnection {
+		t.Errorf("The probe is expected to be passed to the backend, but the actual is %v", err)
+	}
+}
+
+func TestHalfOpenProbesAreAtLeastOne(t *testing.T) {
+	dec := CreateAdvancedCircuitBreakDecorator(1, time.Second, time.Millisecond*20,
+		func(err error) bool { return true }, MockFallbackFn).WithHalfOpenProbes(0, -1)
+	checkCnt(dec.HalfOpenMaxProbes, 1, t)
+	checkCnt(dec.HalfOpenSuccessThreshold, 1, t)
+	// the invalid value set to the field directly still lets one probe pass
+	dec.HalfOpenMaxProbes = 0
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		if err, ok := req.(error); ok {
+			return nil, err
+		}
+		return req, nil
+	})
+	tripAdvancedCircuitBreaker(decFn, 1)
+	time.Sleep(time.Millisecond * 30)
+	if ret, _ := decFn("probe"); ret != "probe" {
+		t.Errorf("The probe is expected to be passed to the backend, but the actual is %v", ret)
+	}
+	if state := dec.State(); state != CircuitBreakerClosed {
+		t.Errorf("The state is expected to be CLOSED, but the actual is %v", state)
+	}
+}
+
+func TestIgnoringResultsOfPreviousState(t *testing.T) {
+	dec := CreateAdvancedCircuitBreakDecorator(1, time.Second, time.Millisecond*20,
+		func(err error) bool { return true }, MockFallbackFn)
+	breaker := dec.breaker
+	now := time.Now()
+	staleGeneration, _, _ := breaker.allow(now, dec)
+	generation, _, _ := breaker.allow(now, dec)
+	breaker.onResult(generation, now, 0, errors.New("error"), dec)
+	// the slow request passed in CLOSED state returns after the circuit breaker is open
+	breaker.onResult(staleGeneration, now, 0, nil, dec)
+	if _, ok, _ := breaker.allow(now, dec); ok {
+		t.Error("The circuit breaker is expected to be still open.")
+	}
+}
+
+func TestOpenByFailureRate(t *testing.T) {
+	errBackend := errors.New("error")
+	dec := CreateAdvancedCircuitBreakDecorator(0, time.Second, time.Second,
+		func(err error) bool { return true }, MockFallbackFn).
+		WithSlidingWindow(CountBasedSlidingWindow, 10, 4).
+		WithFailureRateThreshold(50)
+	breaker := dec.breaker
+	now := time.Now()
+	record := func(err error) {
+		generation, ok, _ := breaker.allow(now, dec)
+		if !ok {
+			t.Fatal("The circuit breaker is expected to be closed.")
+		}
+		breaker.onResult(generation, now, 0, err, dec)
+	}
+	// the failure rate is not evaluated before the minimum number of calls
+	record(errBackend)
+	record(nil)
+	record(nil)
+	record(nil)
+	record(errBackend)
+	record(errBackend)
+	// 3 failures of 6 calls
+	if _, ok, lastErr := breaker.allow(now, dec); ok || lastErr != errBackend {
+		t.Errorf("The circuit breaker is expected to be open with the last error, but the actual is %v, %v",
+			ok, lastErr)
+	}
+}
+
+func TestOpenBySlowCallRateInTimeBasedWindow(t *testing.T) {
+	dec := CreateAdvancedCircuitBreakDecorator(0, time.Second, time.Second,
+		func(err error) bool { return true }, MockFallbackFn).
+		WithSlidingWindow(TimeBasedSlidingWindow, 1, 2).
+		WithSlowCallRateThreshold(60, time.Millisecond*100)
+	breaker := dec.breaker
+	call := func(now time.Time, duration time.Duration) bool {
+		generation, ok, _ := breaker.allow(now, dec)
+		if ok {
+			breaker.onResult(generation, now, duration, nil, dec)
+		}
+		return ok
+	}
+	call(at(0), time.Millisecond*200)
+	call(at(1000), time.Millisecond*10)
+	// the slow call in the previous second is out of the window
+	call(at(1100), time.Millisecond*200)
+	if !call(at(1200), time.Millisecond*200) {
+		t.Error("The circuit breaker is expected to be closed when the slow call rate is 50%.")
+	}
+	if call(at(1300), time.Millisecond*10) {
+		t.Error("The circuit breaker is expected to be open when the slow call rate is 66%.")
+	}
+	// the slow trial request switches back to OPEN
+	call(at(2300), time.Millisecond*200)
+	if call(at(2400), time.Millisecond*10) {
+		t.Error("The circuit breaker is expected to be open after the slow trial request.")
+	}
+	call(at(3400), time.Millisecond*10)
+	if !call(at(3500), time.Millisecond*10) {
+		t.Error("The circuit breaker is expected to be closed after the trial request succeeded.")
+	}
+}
+
+func TestContinuousErrorsWithSlidingWindow(t *testing.T) {
+	dec := CreateAdvancedCircuitBreakDecorator(2, time.Second, time.Second,
+		func(err error) bool { return true }, MockFallbackFn).
+		WithFailureRateThreshold(90)
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		return nil, errors.New("error")
+	})
+	decFn(1)
+	decFn(1)
+	if _, err := decFn(1); err != nil {
+		t.Errorf("The circuit breaker is expected to be open by the continuous errors, but the actual is %v", err)
+	}
+}
+
+func TestStateListenersAndMetrics(t *testing.T) {
+	dec := CreateAdvancedCircuitBreakDecorator(2, time.Second, time.Millisecond*20,
+		func(err error) bool { return true }, MockFallbackFn)
+	var transitions []CircuitBreakerStateTransition
+	dec.AddStateListener(func(transition CircuitBreakerStateTransition) {
+		// the listener can inspect the decorator
+		if state := dec.State(); state != transition.To {
+			t.Errorf("The state is expected to be %v, but the actual is %v", transition.To, state)
+		}
+		transitions = append(transitions, transition)
+	})
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		if err, ok := req.(error); ok {
+			return nil, err
+		}
+		return req, nil
+	})
+	start := time.Now()
+	decFn(1)
+	tripAdvancedCircuitBreaker(decFn, 2)
+	if state := dec.State(); state != CircuitBreakerOpen {
+		t.Errorf("The state is expected to be OPEN, but the actual is %v", state)
+	}
+	decFn(1)
+	time.Sleep(time.Millisecond * 30)
+	decFn(1)
+	expected := []CircuitBreakerStateTransition{
+		{From: CircuitBreakerClosed, To: CircuitBreakerOpen, Reason: ReasonContinuousErrors},
+		{From: CircuitBreakerOpen, To: CircuitBreakerHalfOpen, Reason: ReasonRetryInterval},
+		{From: CircuitBreakerHalfOpen, To: CircuitBreakerClosed, Reason: ReasonTrialsSucceeded},
+	}
+	if len(transitions) != len(expected) {
+		t.Fatalf("The transitions are expected to be %v, but the actual is %v", expected, transitions)
+	}
+	for i, transition := range transitions {
+		if transition.From != expected[i].From || transition.To != expected[i].To ||
+			transition.Reason != expected[i].Reason || transition.Time.Before(start) {
+			t.Errorf("The transition is expected to be %v, but the actual is %v", expected[i], transition)
+		}
+	}
+	metrics := dec.Metrics()
+	if metrics.State != CircuitBreakerClosed || metrics.NumOfSuccesses != 2 ||
+		metrics.NumOfFailures != 2 || metrics.NumOfRejections != 1 ||
+		!metrics.LastTransitionTime.Equal(transitions[2].Time) {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+	if CircuitBreakerHalfOpen.String() != "HALF_OPEN" {
+		t.Errorf("Unexpected state name %s", CircuitBreakerHalfOpen)
 	}
 }
@@ -0,0 +1,190 @@
+package service_decorators
+
+import (
+	"math/rand"
+	"time"
+)
+
+// BackoffStrategy decides the delay before the next retrying of RetryDecorator.
+// The implementations are called by the concurrent requests, so they should be thread-safe.
+type BackoffStrategy interface {
+	// Delay is to get the delay before the retrying.
+	// retryTimes: the times of the retrying, starting from 1
+	// lastDelay: the delay before the last retrying, 0 for the first retrying
+	Delay(retryTimes int, lastDelay time.Duration) time.Duration
+}
+
+// validatedBackoff is implemented by the backoff strategies checked by RetryDecoratorConfig.Build
+type validatedBackoff interface {
+	isValid() bool
+}
+
+// isValidExponentialRange requires the positive base delay and the max delay not less than it,
+// otherwise the delays would be capped to 0 and the retries would be sent without backoff.
+func isValidExponentialRange(baseDelay time.Duration, maxDelay time.Duration) bool {
+	return baseDelay > 0 && maxDelay >= baseDelay
+}
+
+// LinearBackoff increases the delay by the fixed increment:
+// interval + (retry times - 1) * increment
+type LinearBackoff struct {
+	interval  time.Duration
+	increment time.Duration
+}
+
+// CreateLinearBackoff is to create LinearBackoff
+func CreateLinearBackoff(interval time.Duration, increment time.Duration) *LinearBackoff {
+	return &LinearBackoff{interval, increment}
+}
+
+// Delay is to get the delay before the retrying
+func (backoff *LinearBackoff) Delay(retryTimes int, lastDelay time.Duration) time.Duration {
+	return backoff.interval + time.Duration(retryTimes-1)*backoff.increment
+}
+
+func (backoff *LinearBackoff) isValid() bool {
+	return backoff.interval > 0 && backoff.increment >= 0
+}
+
+// ExponentialBackoff doubles the delay for each retrying until the max delay:
+// min(maxDelay, baseDelay * 2 ^ (retry times - 1))
+type ExponentialBackoff struct {
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// CreateExponentialBackoff is to create ExponentialBackoff.
+// baseDelay should be positive and maxDelay should not be less than it,
+// which is checked by RetryDecoratorConfig.Build.
+func CreateExponentialBackoff(baseDelay time.Duration, maxDelay time.Duration) *ExponentialBackoff {
+	return &ExponentialBackoff{baseDelay, maxDelay}
+}
+
+// Delay is to get the delay before the retrying
+func (backoff *ExponentialBackoff) Delay(retryTimes int, lastDelay time.Duration) time.Duration {
+	delay := backoff.baseDelay
+	for i := 1; i < retryTimes && delay < backoff.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > backoff.maxDelay {
+		return backoff.maxDelay
+	}
+	return delay
+}
+
+func (backoff *ExponentialBackoff) isValid() bool {
+	return isValidExponentialRange(backoff.baseDelay, backoff.maxDelay)
+}
+
+// randomDuration returns the random duration in [0, d)
+func randomDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// FullJitterBackoff randomizes the exponential delay in [0, exponential delay),
+// which spreads the retries of the clients failed at the same time.
+type FullJitterBackoff struct {
+	exponential ExponentialBackoff
+}
+
+// CreateFullJitterBackoff is to create FullJitterBackoff
+func CreateFullJitterBackoff(baseDelay time.Duration, maxDelay time.Duration) *FullJitterBackoff {
+	return &FullJitterBackoff{ExponentialBackoff{baseDelay, maxDelay}}
+}
+
+// Delay is to get the delay before the retrying
+func (backoff *FullJitterBackoff) Delay(retryTimes int, lastDelay time.Duration) time.Duration {
+	return randomDuration(backoff.exponential.Delay(retryTimes, lastDelay))
+}
+
+func (backoff *FullJitterBackoff) isValid() bool {
+	return backoff.exponential.isValid()
+}
+
+// EqualJitterBackoff keeps the half of the exponential delay, and randomizes the other half:
+// exponential delay / 2 + random in [0, exponential delay / 2)
+type EqualJitterBackoff struct {
+	exponential ExponentialBackoff
+}
+
+// CreateEqualJitterBackoff is to create EqualJitterBackoff
+func CreateEqualJitterBackoff(baseDelay time.Duration, maxDelay time.Duration) *EqualJitterBackoff {
+	return &EqualJitterBackoff{ExponentialBackoff{baseDelay, maxDelay}}
+}
+
+// Delay is to get the delay before the retrying
+func (backoff *EqualJitterBackoff) Delay(retryTimes int, lastDelay time.Duration) time.Duration {
+	half := backoff.exponential.Delay(retryTimes, lastDelay) / 2
+	return half + randomDuration(half)
+}
+
+func (backoff *EqualJitterBackoff) isValid() bool {
+	return backoff.exponential.isValid()
+}
+
+// DecorrelatedJitterBackoff decides the delay by the last delay instead of the retry times:
+// min(maxDelay, random in [baseDelay, last delay * 3))
+type DecorrelatedJitterBackoff struct {
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// CreateDecorrelatedJitterBackoff is to create DecorrelatedJitterBackoff
+func CreateDecorrelatedJitterBackoff(baseDelay time.Duration,
+	maxDelay time.Duration) *DecorrelatedJitterBackoff {
+	return &DecorrelatedJitterBackoff{baseDelay, maxDelay}
+}
+
+// Delay is to get the delay before the retrying
+func (backoff *DecorrelatedJitterBackoff) Delay(retryTimes int, lastDelay time.Duration) time.Duration {
+	if lastDelay < backoff.baseDelay {
+		lastDelay = backoff.baseDelay
+	}
+	delay := backoff.baseDelay + randomDuration(lastDelay*3-backoff.baseDelay)
+	if delay > backoff.maxDelay {
+		return backoff.maxDelay
+	}
+	return delay
+}
+
+func (backoff *DecorrelatedJitterBackoff) isValid() bool {
+	return isValidExponentialRange(backoff.baseDelay, backoff.maxDelay)
+}
+
+// FixedScheduleBackoff takes the delays from the schedule in order,
+// the last one is used when the retry times is beyond the schedule.
+type FixedScheduleBackoff struct {
+	schedule []time.Duration
+}
+
+// CreateFixedScheduleBackoff is to create FixedScheduleBackoff, e.g.
+// CreateFixedScheduleBackoff(time.Millisecond*10, time.Millisecond*100, time.Second)
+func CreateFixedScheduleBackoff(schedule ...time.Duration) *FixedScheduleBackoff {
+	return &FixedScheduleBackoff{schedule}
+}
+
+// Delay is to get the delay before the retrying
+func (backoff *FixedScheduleBackoff) Delay(retryTimes int, lastDelay time.Duration) time.Duration {
+	if len(backoff.schedule) == 0 || retryTimes < 1 {
+		return 0
+	}
+	if retryTimes > len(backoff.schedule) {
+		retryTimes = len(backoff.schedule)
+	}
+	return backoff.schedule[retryTimes-1]
+}
+
+func (backoff *FixedScheduleBackoff) isValid() bool {
+	if len(backoff.schedule) == 0 {
+		return false
+	}
+	for _, delay := range backoff.schedule {
+		if delay < 0 {
+			return false
+		}
+	}
+	return true
+}
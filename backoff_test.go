@@ -0,0 +1,60 @@
+package service_decorators
+
+import (
+	"testing"
+	"time"
+)
+
+func checkDelays(backoff BackoffStrategy, expected []time.Duration, t *testing.T) {
+	var delay time.Duration
+	for i, expectedDelay := range expected {
+		delay = backoff.Delay(i+1, delay)
+		if delay != expectedDelay {
+			t.Errorf("The delay of the retrying %d is expected to be %v, but the actual is %v",
+				i+1, expectedDelay, delay)
+		}
+	}
+}
+
+func TestLinearBackoff(t *testing.T) {
+	checkDelays(CreateLinearBackoff(time.Millisecond*10, time.Millisecond*5),
+		[]time.Duration{time.Millisecond * 10, time.Millisecond * 15, time.Millisecond * 20}, t)
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	checkDelays(CreateExponentialBackoff(time.Millisecond*10, time.Millisecond*50),
+		[]time.Duration{time.Millisecond * 10, time.Millisecond * 20, time.Millisecond * 40,
+			time.Millisecond * 50, time.Millisecond * 50}, t)
+	// the delay doesn't overflow with the large retry times
+	if delay := CreateExponentialBackoff(time.Second, time.Hour).Delay(1000, 0); delay != time.Hour {
+		t.Errorf("The delay is expected to be capped, but the actual is %v", delay)
+	}
+}
+
+func TestFixedScheduleBackoff(t *testing.T) {
+	checkDelays(CreateFixedScheduleBackoff(time.Millisecond, time.Millisecond*100),
+		[]time.Duration{time.Millisecond, time.Millisecond * 100, time.Millisecond * 100}, t)
+}
+
+func checkDelayInRange(backoff BackoffStrategy, retryTimes int, lastDelay time.Duration,
+	min time.Duration, max time.Duration, t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if delay := backoff.Delay(retryTimes, lastDelay); delay < min || delay > max {
+			t.Fatalf("The delay is expected to be in [%v, %v], but the actual is %v", min, max, delay)
+		}
+	}
+}
+
+func TestJitterBackoffs(t *testing.T) {
+	fullJitter := CreateFullJitterBackoff(time.Millisecond*10, time.Millisecond*50)
+	checkDelayInRange(fullJitter, 3, 0, 0, time.Millisecond*40, t)
+	checkDelayInRange(fullJitter, 10, 0, 0, time.Millisecond*50, t)
+
+	equalJitter := CreateEqualJitterBackoff(time.Millisecond*10, time.Millisecond*50)
+	checkDelayInRange(equalJitter, 3, 0, time.Millisecond*20, time.Millisecond*40, t)
+
+	decorrelatedJitter := CreateDecorrelatedJitterBackoff(time.Millisecond*10, time.Millisecond*100)
+	checkDelayInRange(decorrelatedJitter, 1, 0, time.Millisecond*10, time.Millisecond*30, t)
+	checkDelayInRange(decorrelatedJitter, 2, time.Millisecond*20, time.Millisecond*10, time.Millisecond*60, t)
+	checkDelayInRange(decorrelatedJitter, 3, time.Millisecond*60, time.Millisecond*10, time.Millisecond*100, t)
+}
@@ -0,0 +1,210 @@
+package service_decorators
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrorInvalidDecoratorsOrder occurred when the order of the decorators in the chain is wrong
+var ErrorInvalidDecoratorsOrder = errors.New("the order of the decorators is invalid")
+
+// ChainIssueLevel is the severity of the issue found in the chain
+type ChainIssueLevel int
+
+const (
+	// ChainIssueWarning means the order works but some functions of the decorators might not take effect
+	ChainIssueWarning ChainIssueLevel = iota
+	// ChainIssueError means the order is wrong, the chain can't be built
+	ChainIssueError
+)
+
+func (level ChainIssueLevel) String() string {
+	if level == ChainIssueError {
+		return "error"
+	}
+	return "warning"
+}
+
+// ChainIssue describes the issue about the order of two decorators in the chain
+type ChainIssue struct {
+	Level ChainIssueLevel
+	// Outer and Inner are the positions of the decorators in the chain
+	Outer   int
+	Inner   int
+	Message string
+}
+
+func (issue ChainIssue) String() string {
+	return fmt.Sprintf("%s: [%d] -> [%d] %s", issue.Level, issue.Outer, issue.Inner, issue.Message)
+}
+
+// chainRule checks the outer and inner decorators, which have been unwrapped from the adapters.
+// It returns false when the order of the decorators is fine.
+type chainRule func(outer interface{}, inner interface{}) (ChainIssueLevel, string, bool)
+
+// statefulCircuitBreaker is implemented by the circuit breakers opened by the counted errors,
+// e.g. AdvancedCircuitBreakDecorator and KeyedCircuitBreakDecorator.
+type statefulCircuitBreaker interface {
+	isStatefulCircuitBreaker()
+}
+
+var chainRules = []chainRule{
+	// refer to "AdvancedCircuitBreakDecorator" section of README
+	func(outer interface{}, inner interface{}) (ChainIssueLevel, string, bool) {
+		_, isOuterCb := outer.(*CircuitBreakDecorator)
+		_, isInnerStatefulCb := inner.(statefulCircuitBreaker)
+		return ChainIssueError,
+			"AdvancedCircuitBreakDecorator and KeyedCircuitBreakDecorator should be put out of CircuitBreakDecorator " +
+				"to get timeout or beyond max concurrency errors",
+			isOuterCb && isInnerStatefulCb
+	},
+	func(outer interface{}, inner interface{}) (ChainIssueLevel, string, bool) {
+		_, isOuterStatefulCb := outer.(statefulCircuitBreaker)
+		return ChainIssueWarning,
+			"the fallback functions of CircuitBreakDecorator hide the errors " +
+				"from AdvancedCircuitBreakDecorator and KeyedCircuitBreakDecorator",
+			isOuterStatefulCb && hasCircuitBreakFallback(inner)
+	},
+	func(outer interface{}, inner interface{}) (ChainIssueLevel, string, bool) {
+		_, isOuterRetry := outer.(*RetryDecorator)
+		_, isInnerStatefulCb := inner.(statefulCircuitBreaker)
+		return ChainIssueWarning,
+			"the errors processed by the circuit breaker's fallback functions " +
+				"would not be retried by RetryDecorator",
+			isOuterRetry && (isInnerStatefulCb || hasCircuitBreakFallback(inner))
+	},
+}
+
+func hasCircuitBreakFallback(dec interface{}) bool {
+	cbDec, ok := dec.(*CircuitBreakDecorator)
+	return ok && (cbDec.Config.timeoutFallbackFunction != nil ||
+		cbDec.Config.beyondMaxConcurrencyFallbackFunction != nil)
+}
+
+// unwrapDecorator is to get the decorator adapted by AsDecorator and AsContextDecorator
+func unwrapDecorator(dec interface{}) interface{} {
+	for {
+		switch adapter := dec.(type) {
+		case *decoratorAdapter:
+			dec = adapter.dec
+		case *contextDecoratorAdapter:
+			dec = adapter.dec
+		default:
+			return dec
+		}
+	}
+}
+
+// Chain is to compose the decorators in order.
+// The first decorator is the outermost one, which processes the request firstly.
+// The known-bad orders of the decorators would be rejected or warned when building.
+type Chain struct {
+	decorators     []Decorator
+	warningHandler func(issue ChainIssue)
+}
+
+// CreateChain is to create a Chain with the decorators,
+// the first decorator is the outermost one.
+func CreateChain(decorators ...Decorator) *Chain {
+	return &Chain{decorators: append([]Decorator{}, decorators...)}
+}
+
+// Append is to append the decorators as the inner ones of the chain
+func (chain *Chain) Append(decorators ...Decorator) *Chain {
+	chain.decorators = append(chain.decorators, decorators...)
+	return chain
+}
+
+// WithWarningHandler is to set the handler of the warnings found when building
+func (chain *Chain) WithWarningHandler(handler func(issue ChainIssue)) *Chain {
+	chain.warningHandler = handler
+	return chain
+}
+
+// Validate is to check the order of the decorators
+func (chain *Chain) Validate() []ChainIssue {
+	var issues []ChainIssue
+	for i, outer := range chain.decorators {
+		for j := i + 1; j < len(chain.decorators); j++ {
+			for _, rule := range chainRules {
+				level, msg, hasIssue := rule(unwrapDecorator(outer), unwrapDecorator(chain.decorators[j]))
+				if hasIssue {
+					issues = append(issues, ChainIssue{level, i, j, msg})
+				}
+			}
+		}
+	}
+	return issues
+}
+
+func (chain *Chain) check() error {
+	var errMsgs []string
+	for _, issue := range chain.Validate() {
+		if issue.Level == ChainIssueError {
+			errMsgs = append(errMsgs, issue.String())
+			continue
+		}
+		if chain.warningHandler != nil {
+			chain.warningHandler(issue)
+		}
+	}
+	if len(errMsgs) > 0 {
+		return fmt.Errorf("%w\n%s", ErrorInvalidDecoratorsOrder, strings.Join(errMsgs, "\n"))
+	}
+	return nil
+}
+
+// Build is to decorate the service function with the decorators in the chain
+func (chain *Chain) Build(fn ServiceFunc) (ServiceFunc, error) {
+	if err := chain.check(); err != nil {
+		return nil, err
+	}
+	for i := len(chain.decorators) - 1; i >= 0; i-- {
+		fn = chain.decorators[i].Decorate(fn)
+	}
+	return fn, nil
+}
+
+// BuildContext is to decorate the context-aware service function with the decorators in the chain
+func (chain *Chain) BuildContext(fn ContextServiceFunc) (ContextServiceFunc, error) {
+	if err := chain.check(); err != nil {
+		return nil, err
+	}
+	for i := len(chain.decorators) - 1; i >= 0; i-- {
+		fn = AsContextDecorator(chain.decorators[i]).DecorateContext(fn)
+	}
+	return fn, nil
+}
+
+// String prints the layering of the decorators, e.g.
+//
+//	AdvancedCircuitBreakDecorator
+//	└─ CircuitBreakDecorator
+//	   └─ ServiceFunc
+func (chain *Chain) String() string {
+	var builder strings.Builder
+	decorators := append(append([]Decorator{}, chain.decorators...), nil)
+	for i, dec := range decorators {
+		name := "ServiceFunc"
+		if dec != nil {
+			name = decoratorName(dec)
+		}
+		if i > 0 {
+			builder.WriteString("\n")
+			builder.WriteString(strings.Repeat("   ", i-1))
+			builder.WriteString("└─ ")
+		}
+		builder.WriteString(name)
+	}
+	return builder.String()
+}
+
+func decoratorName(dec Decorator) string {
+	t := reflect.TypeOf(unwrapDecorator(dec))
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
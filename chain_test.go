@@ -0,0 +1,101 @@
+package service_decorators
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChainBuildInOrder(t *testing.T) {
+	outerDec := createDemoDecorator()
+	innerDec := createDemoDecorator()
+	retryDec, err := CreateRetryDecorator(1, time.Millisecond, 0, retriableChecker)
+	checkErr(err, t)
+	decFn, err := CreateChain(outerDec, retryDec).Append(innerDec).Build(
+		func(req Request) (Response, error) {
+			if innerDec.InvokedCnt < 2 {
+				return nil, ErrorConnection
+			}
+			return req, nil
+		})
+	checkErr(err, t)
+	_, err = decFn(1)
+	checkErr(err, t)
+	checkCnt(outerDec.InvokedCnt, 1, t)
+	checkCnt(innerDec.InvokedCnt, 2, t)
+}
+
+func TestChainRejectsAdvancedCircuitBreakInsideCircuitBreak(t *testing.T) {
+	cbDec, err := CreateCircuitBreakDecorator().Build()
+	checkErr(err, t)
+	advCbDec := CreateAdvancedCircuitBreakDecorator(3, time.Second, time.Second,
+		func(err error) bool { return true }, MockFallbackFn)
+	_, err = CreateChain(cbDec, advCbDec).Build(MockServiceFn)
+	if !errors.Is(err, ErrorInvalidDecoratorsOrder) {
+		t.Errorf("ErrorInvalidDecoratorsOrder is expected, but the actual is %v", err)
+	}
+	_, err = CreateChain(advCbDec, cbDec).Build(MockServiceFn)
+	checkErr(err, t)
+}
+
+func TestChainRejectsKeyedAndWrappedCircuitBreakInsideCircuitBreak(t *testing.T) {
+	cbDec, err := CreateCircuitBreakDecorator().Build()
+	checkErr(err, t)
+	advCbDec := CreateAdvancedCircuitBreakDecorator(3, time.Second, time.Second,
+		func(err error) bool { return true }, MockFallbackFn)
+	keyedCbDec, err := CreateKeyedCircuitBreakDecoratorConfig(advCbDec, tenantOf).Build()
+	checkErr(err, t)
+	for _, inner := range []Decorator{keyedCbDec, &decoratorAdapter{advCbDec}} {
+		_, err = CreateChain(cbDec, inner).Build(MockServiceFn)
+		if !errors.Is(err, ErrorInvalidDecoratorsOrder) {
+			t.Errorf("ErrorInvalidDecoratorsOrder is expected for %T, but the actual is %v", inner, err)
+		}
+	}
+}
+
+func TestChainDoesNotModifyTheDecoratorsOfCaller(t *testing.T) {
+	retryDec, err := CreateRetryDecorator(1, time.Millisecond, 0, retriableChecker)
+	checkErr(err, t)
+	decorators := make([]Decorator, 1, 2)
+	decorators[0] = retryDec
+	chain := CreateChain(decorators...)
+	chain.Append(createDemoDecorator())
+	t.Log("\n" + chain.String())
+	if extended := decorators[:2]; extended[1] != nil {
+		t.Errorf("The array of the caller is expected not to be modified, but the actual is %v", extended)
+	}
+}
+
+func TestChainWarnsRetryOutsideCircuitBreakWithFallback(t *testing.T) {
+	cbDec, err := CreateCircuitBreakDecorator().
+		WithTimeoutFallbackFunction(MockFallbackFn).Build()
+	checkErr(err, t)
+	retryDec, err := CreateRetryDecorator(1, time.Millisecond, 0, retriableChecker)
+	checkErr(err, t)
+	var warnings []ChainIssue
+	_, err = CreateChain(retryDec, createDemoDecorator(), cbDec).
+		WithWarningHandler(func(issue ChainIssue) {
+			warnings = append(warnings, issue)
+		}).Build(MockServiceFn)
+	checkErr(err, t)
+	if len(warnings) != 1 || warnings[0].Outer != 0 || warnings[0].Inner != 2 {
+		t.Errorf("Unexpected warnings %v", warnings)
+	}
+}
+
+func TestChainString(t *testing.T) {
+	retryDec, err := CreateRetryDecorator(1, time.Millisecond, 0, retriableChecker)
+	checkErr(err, t)
+	cbDec, err := CreateCircuitBreakDecorator().Build()
+	checkErr(err, t)
+	layering := CreateChain(cbDec, retryDec).String()
+	expected := strings.Join([]string{
+		"CircuitBreakDecorator",
+		"└─ RetryDecorator",
+		"   └─ ServiceFunc",
+	}, "\n")
+	if layering != expected {
+		t.Errorf("The expected layering is\n%s\nbut the actual is\n%s", expected, layering)
+	}
+}
@@ -1,6 +1,7 @@
 package service_decorators
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"math/rand"
@@ -68,24 +69,34 @@ func CreateChaosEngineeringDecorator(configStorage ConfigStorage, configName str
 
 // Decorate function is to add chaos engineering logic to the function
 func (dec *ChaosEngineeringDecorator) Decorate(innerFn ServiceFunc) ServiceFunc {
-	return func(req Request) (Response, error) {
+	return ToServiceFunc(dec.DecorateContext(ToContextServiceFunc(innerFn)))
+}
+
+// DecorateContext function is to add chaos engineering logic to the context-aware function.
+// The injected slow response would be interrupted when the context is done.
+func (dec *ChaosEngineeringDecorator) DecorateContext(innerFn ContextServiceFunc) ContextServiceFunc {
+	return func(ctx context.Context, req Request) (Response, error) {
 		config, ok := dec.config.Load().(*ChaosEngineeringConfig)
 		if !ok || config == nil {
-			return innerFn(req)
+			return innerFn(ctx, req)
 		}
 		if !config.IsToInjectChaos {
-			return innerFn(req)
+			return innerFn(ctx, req)
 		}
 		reqSeri := rand.Intn(99) + 1
 		if reqSeri <= config.ChaosRate {
 			if config.AdditionalResponseTime > 0 {
-				time.Sleep(time.Duration(config.AdditionalResponseTime) * time.Millisecond)
+				err := sleepWithContext(ctx,
+					time.Duration(config.AdditionalResponseTime)*time.Millisecond)
+				if err != nil {
+					return nil, err
+				}
 			}
 			if dec.chaosResponseFn != nil {
 				return dec.chaosResponseFn(req)
 			}
 		}
-		return innerFn(req)
+		return innerFn(ctx, req)
 	}
 }
 
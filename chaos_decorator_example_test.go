@@ -9,14 +9,14 @@ import (
 	"github.com/hashicorp/consul/api"
 )
 
-// Example_ChaosEngineeringDecorator_InjectError is the example for error injection.
+// ExampleChaosEngineeringDecorator_injectError is the example for error injection.
 // To run the example, put the following configuration into Consul KV storage with the key "ChaosExample"
 // {
 //   "IsToInjectChaos" : true,
 //   "AdditionalResponseTime" : 0,
 //   "ChaosRate" : 10
 // }
-func Example_ChaosEngineeringDecorator_InjectError() {
+func ExampleChaosEngineeringDecorator_injectError() {
 	serviceFn := func(req Request) (Response, error) {
 		return "Service is invoked", nil
 	}
@@ -42,14 +42,14 @@ func Example_ChaosEngineeringDecorator_InjectError() {
 	//You have 10% probability to get "Error Injection"
 }
 
-// Example_ChaosEngineeringDecorator_InjectError is the example for slow response injection.
+// ExampleChaosEngineeringDecorator_injectSlowResponse is the example for slow response injection.
 // To run the example, put the following configuration into Consul KV storage with the key "ChaosExample"
 // {
 //   "IsToInjectChaos" : true,
 //   "AdditionalResponseTime" : 100, // response time will be increased 100ms
 //   "ChaosRate" : 10
 // }
-func Example_ChaosEngineeringDecorator_InjectSlowResponse() {
+func ExampleChaosEngineeringDecorator_injectSlowResponse() {
 	serviceFn := func(req Request) (Response, error) {
 		return "Service is invoked", nil
 	}
@@ -79,10 +79,10 @@ func Example_ChaosEngineeringDecorator_InjectSlowResponse() {
 
 }
 
-// func TestExample_ChaosEngineeringDecorator_InjectError(t *testing.T) {
-// 	Example_ChaosEngineeringDecorator_InjectError()
+// func TestExampleChaosEngineeringDecorator_injectError(t *testing.T) {
+// 	ExampleChaosEngineeringDecorator_injectError()
 // }
 
-func TestExample_ChaosEngineeringDecorator_InjectSlowResponse(t *testing.T) {
-	Example_ChaosEngineeringDecorator_InjectSlowResponse()
+func TestExampleChaosEngineeringDecorator_injectSlowResponse(t *testing.T) {
+	ExampleChaosEngineeringDecorator_injectSlowResponse()
 }
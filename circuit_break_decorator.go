@@ -1,7 +1,12 @@
 package service_decorators
 
 import (
+	"container/list"
+	"context"
+	"encoding/json"
 	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,14 +31,179 @@ type CircuitBreakDecoratorConfig struct {
 	// if BeyondMaxConcurrencyFallbackFunction is defined,
 	// it would be called when concurrency beyonding error occurring
 	beyondMaxConcurrencyFallbackFunction ServiceFallbackFunc
+
+	// if ReleaseTokenOnTimeout is set,
+	// the concurrency token would be returned when timeout error occurring
+	// instead of when the inner function returning
+	releaseTokenOnTimeout bool
+
+	// if MaxQueueLength is set, the requests beyond the max concurrency
+	// would wait in the queue for the token up to MaxQueueTime
+	maxQueueLength int
+	maxQueueTime   time.Duration
+	queueOrder     QueueOrder
+
+	// if ConfigStorage is set, the timeout and max concurrency settings
+	// would be read from the storage and refreshed periodically
+	configStorage   ConfigStorage
+	configName      string
+	refreshInterval time.Duration
+
+	// if OverrideConfigStorage is set, the override would be read from the storage
+	// and refreshed periodically
+	overrideConfigStorage   ConfigStorage
+	overrideConfigName      string
+	overrideRefreshInterval time.Duration
+}
+
+// QueueOrder decides which waiting request gets the released token first
+type QueueOrder int
+
+const (
+	// FIFOQueue serves the request waiting longest first, it is the default one.
+	FIFOQueue QueueOrder = iota
+	// LIFOQueue serves the latest request first, which keeps the latency of most requests low
+	// when the queue is backlogged, since the early ones are likely to be timeout at the caller.
+	LIFOQueue
+)
+
+// CircuitBreakConfig is the circuit break configuration stored in ConfigStorage.
+type CircuitBreakConfig struct {
+	Timeout            int `json:"Timeout"`            //The function execution timeout (milliseconds)
+	MaxCurrentRequests int `json:"MaxCurrentRequests"` //The max concurrency, 0 means no limit
+}
+
+type circuitBreakSettings struct {
+	timeout            time.Duration
+	maxCurrentRequests int
 }
 
 // CircuitBreakDecorator provides the circuit break,
 // fallback, concurrency control
 type CircuitBreakDecorator struct {
 	// CircuitBreakDecoratorConfig
-	Config      *CircuitBreakDecoratorConfig
-	tokenBuffer chan struct{}
+	// The timeout and max concurrency in Config are the initial settings,
+	// which could be changed by the configurations in ConfigStorage at runtime.
+	Config    *CircuitBreakDecoratorConfig
+	settings  atomic.Value
+	tokens    *concurrencyTokens
+	overrides overrideSwitch
+}
+
+// concurrencyTokens is to count the tokens taken by the in-flight requests.
+// The limit can be changed at runtime, when the limit is shrunk,
+// the new requests can't get the token until the in-flight requests drop below the limit.
+// When the queue is enabled, the requests wait for the released tokens in the queue.
+type concurrencyTokens struct {
+	lock           sync.Mutex
+	limit          int
+	inUse          int
+	waiters        *list.List
+	maxQueueLength int
+	queueOrder     QueueOrder
+}
+
+// tokenWaiter is the request waiting in the queue,
+// ready is closed when the token is handed over to it.
+type tokenWaiter struct {
+	ready chan struct{}
+}
+
+func createConcurrencyTokens(limit int, maxQueueLength int, queueOrder QueueOrder) *concurrencyTokens {
+	return &concurrencyTokens{
+		limit:          limit,
+		waiters:        list.New(),
+		maxQueueLength: maxQueueLength,
+		queueOrder:     queueOrder,
+	}
+}
+
+func (tokens *concurrencyTokens) setLimit(limit int) {
+	tokens.lock.Lock()
+	defer tokens.lock.Unlock()
+	tokens.limit = limit
+	for tokens.waiters.Len() > 0 && tokens.isAvailable() {
+		tokens.inUse++
+		tokens.handOver()
+	}
+}
+
+func (tokens *concurrencyTokens) inFlight() int {
+	tokens.lock.Lock()
+	defer tokens.lock.Unlock()
+	return tokens.inUse
+}
+
+func (tokens *concurrencyTokens) queueLength() int {
+	tokens.lock.Lock()
+	defer tokens.lock.Unlock()
+	return tokens.waiters.Len()
+}
+
+func (tokens *concurrencyTokens) isAvailable() bool {
+	return tokens.limit <= 0 || tokens.inUse < tokens.limit
+}
+
+// handOver is to pass the token to the next waiter by the queue order
+func (tokens *concurrencyTokens) handOver() {
+	next := tokens.waiters.Front()
+	if tokens.queueOrder == LIFOQueue {
+		next = tokens.waiters.Back()
+	}
+	tokens.waiters.Remove(next)
+	close(next.Value.(*tokenWaiter).ready)
+}
+
+// get is to take a token, the token is always available when the limit is 0.
+// When the token is not available, the request waits in the queue
+// until the max queue time elapses or the context is done.
+func (tokens *concurrencyTokens) get(ctx context.Context, maxQueueTime time.Duration) bool {
+	tokens.lock.Lock()
+	if tokens.waiters.Len() == 0 && tokens.isAvailable() {
+		tokens.inUse++
+		tokens.lock.Unlock()
+		return true
+	}
+	if tokens.waiters.Len() >= tokens.maxQueueLength {
+		tokens.lock.Unlock()
+		return false
+	}
+	waiter := &tokenWaiter{make(chan struct{})}
+	elem := tokens.waiters.PushBack(waiter)
+	tokens.lock.Unlock()
+
+	timer := time.NewTimer(maxQueueTime)
+	defer timer.Stop()
+	select {
+	case <-waiter.ready:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	tokens.lock.Lock()
+	defer tokens.lock.Unlock()
+	select {
+	case <-waiter.ready:
+		// the token has been handed over before leaving the queue
+		return true
+	default:
+		tokens.waiters.Remove(elem)
+		return false
+	}
+}
+
+// release is to return the token, which is handed over to the waiter directly if there is one.
+func (tokens *concurrencyTokens) release() {
+	tokens.lock.Lock()
+	defer tokens.lock.Unlock()
+	if tokens.inUse <= 0 {
+		panic("There's a fatal bug here. Unexpected token has been returned.")
+	}
+	tokens.inUse--
+	if tokens.waiters.Len() > 0 && tokens.isAvailable() {
+		tokens.inUse++
+		tokens.handOver()
+	}
 }
 
 type serviceFuncResponse struct {
@@ -76,78 +246,233 @@ func (config *CircuitBreakDecoratorConfig) WithBeyondMaxConcurrencyFallbackFunct
 	return config
 }
 
+// WithReleaseTokenOnTimeout is to return the concurrency token when timeout error occurring.
+// By default, the token is held until the inner function returns, even the invoking has been timeout.
+// With the setting, the inner function which doesn't respect the context cancellation
+// could keep running beyond the max concurrency.
+func (config *CircuitBreakDecoratorConfig) WithReleaseTokenOnTimeout() *CircuitBreakDecoratorConfig {
+	config.releaseTokenOnTimeout = true
+	return config
+}
+
+// WithQueue is to let the requests beyond the max concurrency wait for the token in the queue,
+// instead of being rejected at once. The request is rejected with ErrorCircuitBreakTooManyConcurrentRequests
+// when the queue is full or it has waited for maxQueueTime.
+// The time waiting in the queue is not counted in the timeout.
+// maxQueueLength: the max number of the waiting requests
+// maxQueueTime: the max waiting time of a request
+// order: the order of serving the waiting requests
+func (config *CircuitBreakDecoratorConfig) WithQueue(maxQueueLength int, maxQueueTime time.Duration,
+	order QueueOrder) *CircuitBreakDecoratorConfig {
+	config.maxQueueLength = maxQueueLength
+	config.maxQueueTime = maxQueueTime
+	config.queueOrder = order
+	return config
+}
+
+// WithConfigStorage is to read the timeout and max concurrency settings (CircuitBreakConfig)
+// from the storage, and refresh them periodically.
+// The invalid configurations would be ignored and the current settings are kept.
+// configStore: the storage is used to store the circuit break configurations
+// configName: the config name in the storage
+// refreshInterval: the interval of reloading the configurations, 0 means no reloading
+func (config *CircuitBreakDecoratorConfig) WithConfigStorage(configStorage ConfigStorage,
+	configName string, refreshInterval time.Duration) *CircuitBreakDecoratorConfig {
+	config.configStorage = configStorage
+	config.configName = configName
+	config.refreshInterval = refreshInterval
+	return config
+}
+
+// WithOverrideConfigStorage is to read the override (CircuitBreakerOverrideConfig) from the storage,
+// and refresh it periodically. The override in the storage is applied when it is changed,
+// and the invalid configurations are ignored.
+// configStore: the storage is used to store the override configuration
+// configName: the config name in the storage
+// refreshInterval: the interval of reloading the configuration, 0 means no reloading
+func (config *CircuitBreakDecoratorConfig) WithOverrideConfigStorage(configStorage ConfigStorage,
+	configName string, refreshInterval time.Duration) *CircuitBreakDecoratorConfig {
+	config.overrideConfigStorage = configStorage
+	config.overrideConfigName = configName
+	config.overrideRefreshInterval = refreshInterval
+	return config
+}
+
+func createCircuitBreakSettings(timeout time.Duration,
+	maxCurrentRequests int) (*circuitBreakSettings, error) {
+	if maxCurrentRequests < 0 {
+		return nil, errors.New("invalid max current requests setting")
+	}
+	if timeout <= 0 {
+		return nil, errors.New("invalid timeout setting")
+	}
+	return &circuitBreakSettings{timeout, maxCurrentRequests}, nil
+}
+
+func getCircuitBreakSettingsFromStorage(configStorage ConfigStorage,
+	configName string) (*circuitBreakSettings, error) {
+	configStr, err := configStorage.Get(configName)
+	if err != nil {
+		return nil, err
+	}
+	config := CircuitBreakConfig{}
+	err = json.Unmarshal([]byte(configStr), &config)
+	if err != nil {
+		return nil, err
+	}
+	return createCircuitBreakSettings(time.Duration(config.Timeout)*time.Millisecond,
+		config.MaxCurrentRequests)
+}
+
 // Build will create CircuitBreakDecorator with the settings defined by WithXX method chain
 func (config *CircuitBreakDecoratorConfig) Build() (*CircuitBreakDecorator, error) {
-	var tokenBuf chan struct{}
-	if config.maxCurrentRequests < 0 {
-		return nil, errors.New("invalid max current requests setting")
+	settings, err := createCircuitBreakSettings(config.timeout, config.maxCurrentRequests)
+	if err != nil {
+		return nil, err
+	}
+	if config.maxQueueLength < 0 || (config.maxQueueLength > 0 && config.maxQueueTime <= 0) ||
+		config.queueOrder < FIFOQueue || config.queueOrder > LIFOQueue {
+		return nil, errors.New("invalid queue setting")
 	}
-	if config.maxCurrentRequests > 0 {
-		tokenBuf = make(chan struct{}, config.maxCurrentRequests)
-		for i := 0; i < config.maxCurrentRequests; i++ {
-			tokenBuf <- struct{}{}
+	if config.configStorage != nil {
+		settings, err = getCircuitBreakSettingsFromStorage(config.configStorage, config.configName)
+		if err != nil {
+			return nil, err
 		}
 	}
-	return &CircuitBreakDecorator{
-		Config:      config,
-		tokenBuffer: tokenBuf,
-	}, nil
+	dec := &CircuitBreakDecorator{
+		Config: config,
+		tokens: createConcurrencyTokens(settings.maxCurrentRequests,
+			config.maxQueueLength, config.queueOrder),
+	}
+	dec.settings.Store(settings)
+	if config.configStorage != nil {
+		go dec.refreshConfig(config.refreshInterval, config.configStorage, config.configName)
+	}
+	if config.overrideConfigStorage != nil {
+		dec.overrides.watch(config.overrideConfigStorage, config.overrideConfigName,
+			config.overrideRefreshInterval)
+	}
+	return dec, nil
 }
 
-func (dec *CircuitBreakDecorator) getToken() bool {
-	select {
-	case <-dec.tokenBuffer:
-		return true
-	default:
-		return false
-	}
+func (dec *CircuitBreakDecorator) loadSettings() *circuitBreakSettings {
+	return dec.settings.Load().(*circuitBreakSettings)
 }
 
-func (dec *CircuitBreakDecorator) releaseToken() {
-	select {
-	case dec.tokenBuffer <- struct{}{}:
+func (dec *CircuitBreakDecorator) updateSettings(settings *circuitBreakSettings) {
+	dec.tokens.setLimit(settings.maxCurrentRequests)
+	dec.settings.Store(settings)
+}
+
+func (dec *CircuitBreakDecorator) refreshConfig(
+	refreshInterval time.Duration, configStorage ConfigStorage,
+	configName string) {
+	if refreshInterval <= 0 {
 		return
-	default:
-		panic("There's a fatal bug here. Unexpected token has been returned.")
 	}
+	for _ = range time.Tick(refreshInterval) {
+		updatedSettings, err := getCircuitBreakSettingsFromStorage(configStorage, configName)
+		if err != nil {
+			continue
+		}
+		dec.updateSettings(updatedSettings)
+	}
+}
+
+// SetOverride is to override the circuit breaker manually, NoOverride is to cancel the override.
+// With ForceOpen, the requests are rejected with ErrorCircuitBreakerForcedOpen,
+// which is processed by the fallback function for beyonding max concurrency if it is set.
+// With ForceClosed, the max concurrency is not limited, but the timeout still works.
+// With Disabled, the requests are passed to the inner function directly.
+func (dec *CircuitBreakDecorator) SetOverride(override CircuitBreakerOverride) {
+	dec.overrides.set(override)
+}
+
+// Override is to get the current override
+func (dec *CircuitBreakDecorator) Override() CircuitBreakerOverride {
+	return dec.overrides.get()
+}
+
+func (dec *CircuitBreakDecorator) getToken(ctx context.Context) bool {
+	return dec.tokens.get(ctx, dec.Config.maxQueueTime)
+}
+
+func (dec *CircuitBreakDecorator) releaseToken() {
+	dec.tokens.release()
 }
 
 // Decorate is to add the circuit break/concurrency control logic to the function
 func (dec *CircuitBreakDecorator) Decorate(innerFn ServiceFunc) ServiceFunc {
-	return func(req Request) (Response, error) {
-		ownToken := false
-		if dec.Config.maxCurrentRequests > 0 {
-			if !dec.getToken() {
-				if dec.Config.beyondMaxConcurrencyFallbackFunction != nil {
-					return dec.Config.
-						beyondMaxConcurrencyFallbackFunction(req,
-							ErrorCircuitBreakTooManyConcurrentRequests)
-				}
-				return nil, ErrorCircuitBreakTooManyConcurrentRequests
+	return ToServiceFunc(dec.DecorateContext(ToContextServiceFunc(innerFn)))
+}
+
+// DecorateContext is to add the circuit break/concurrency control logic to the context-aware function.
+// The context passed to the inner function would be canceled when the invoking is timeout.
+// The context's error would be returned when the context is done before the function returns.
+func (dec *CircuitBreakDecorator) DecorateContext(innerFn ContextServiceFunc) ContextServiceFunc {
+	return func(ctx context.Context, req Request) (Response, error) {
+		override := dec.overrides.get()
+		switch override {
+		case Disabled:
+			return innerFn(ctx, req)
+		case ForceOpen:
+			if dec.Config.beyondMaxConcurrencyFallbackFunction != nil {
+				return dec.Config.beyondMaxConcurrencyFallbackFunction(req, ErrorCircuitBreakerForcedOpen)
 			}
-			ownToken = true
+			return nil, ErrorCircuitBreakerForcedOpen
 		}
-		output := make(chan serviceFuncResponse, 1)
-		go func(r Request, withToken bool) {
-			if withToken {
-				defer dec.releaseToken()
-			}
-			inResp, inErr := innerFn(r)
-			output <- serviceFuncResponse{
-				resp: inResp,
-				err:  inErr,
+		settings := dec.loadSettings()
+		if override == ForceClosed {
+			return dec.invokeWithTimeout(ctx, req, innerFn, settings, func() {})
+		}
+		if !dec.getToken(ctx) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
 			}
-		}(req, ownToken)
-		select {
-		case inServResp := <-output:
-			return inServResp.resp, inServResp.err
-		case <-time.After(dec.Config.timeout):
-			if dec.Config.timeoutFallbackFunction != nil {
-				return dec.Config.timeoutFallbackFunction(req, ErrorCircuitBreakTimeout)
+			if dec.Config.beyondMaxConcurrencyFallbackFunction != nil {
+				return dec.Config.
+					beyondMaxConcurrencyFallbackFunction(req,
+						ErrorCircuitBreakTooManyConcurrentRequests)
 			}
-			return nil, ErrorCircuitBreakTimeout
+			return nil, ErrorCircuitBreakTooManyConcurrentRequests
 		}
-
+		var once sync.Once
+		return dec.invokeWithTimeout(ctx, req, innerFn, settings, func() {
+			once.Do(dec.releaseToken)
+		})
 	}
+}
 
+// invokeWithTimeout is to invoke the inner function with the timeout,
+// release is called when the inner function returns,
+// or at the moment of timeout if ReleaseTokenOnTimeout is set.
+func (dec *CircuitBreakDecorator) invokeWithTimeout(ctx context.Context, req Request,
+	innerFn ContextServiceFunc, settings *circuitBreakSettings, release func()) (Response, error) {
+	innerCtx, cancel := context.WithTimeout(ctx, settings.timeout)
+	defer cancel()
+	output := make(chan serviceFuncResponse, 1)
+	go func(r Request) {
+		defer release()
+		inResp, inErr := innerFn(innerCtx, r)
+		output <- serviceFuncResponse{
+			resp: inResp,
+			err:  inErr,
+		}
+	}(req)
+	select {
+	case inServResp := <-output:
+		return inServResp.resp, inServResp.err
+	case <-innerCtx.Done():
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if dec.Config.releaseTokenOnTimeout {
+			release()
+		}
+		if dec.Config.timeoutFallbackFunction != nil {
+			return dec.Config.timeoutFallbackFunction(req, ErrorCircuitBreakTimeout)
+		}
+		return nil, ErrorCircuitBreakTimeout
+	}
 }
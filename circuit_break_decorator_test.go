@@ -1,8 +1,11 @@
 package service_decorators
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 )
@@ -205,3 +208,293 @@ func TestCircuitBreakInMaxConcurrentReqCaseForProperFequency(t *testing.T) {
 		}
 	}
 }
+
+func mockContextAwareLongRunFn(ctx context.Context, req Request) (Response, error) {
+	select {
+	case <-time.After(time.Second * 1):
+		return req, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func waitForGoroutines(expected int, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= expected || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestCircuitBreakTimeoutCancelsInnerFunction(t *testing.T) {
+	cbDec, err := CreateCircuitBreakDecorator().
+		WithTimeout(time.Millisecond * 5).
+		WithMaxCurrentRequests(100).
+		Build()
+	checkUnexpectedError(err, t)
+	decoratedFn := cbDec.DecorateContext(mockContextAwareLongRunFn)
+	numOfGoroutinesBefore := runtime.NumGoroutine()
+	for round := 0; round < 5; round++ {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := decoratedFn(context.Background(), 10); err != ErrorCircuitBreakTimeout {
+					t.Errorf("ErrorCircuitBreakTimeout is expected, but the actual is %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	if n := waitForGoroutines(numOfGoroutinesBefore, time.Millisecond*200); n > numOfGoroutinesBefore {
+		t.Errorf("The inner functions are still running. Goroutines before: %d, after: %d",
+			numOfGoroutinesBefore, n)
+	}
+}
+
+func TestCircuitBreakReleaseTokenOnTimeout(t *testing.T) {
+	// the inner function takes 50ms to clean up after its context being canceled
+	slowCleanupFn := func(ctx context.Context, req Request) (Response, error) {
+		<-ctx.Done()
+		time.Sleep(time.Millisecond * 50)
+		return nil, ctx.Err()
+	}
+	checkTokens := func(releaseOnTimeout bool) (int, int) {
+		config := CreateCircuitBreakDecorator().
+			WithTimeout(time.Millisecond * 5).
+			WithMaxCurrentRequests(2)
+		if releaseOnTimeout {
+			config = config.WithReleaseTokenOnTimeout()
+		}
+		cbDec, err := config.Build()
+		checkUnexpectedError(err, t)
+		decoratedFn := cbDec.DecorateContext(slowCleanupFn)
+		cntTimeout, cntTooMany := 0, 0
+		for i := 0; i < 6; i++ {
+			_, err := decoratedFn(context.Background(), 10)
+			switch err {
+			case ErrorCircuitBreakTimeout:
+				cntTimeout++
+			case ErrorCircuitBreakTooManyConcurrentRequests:
+				cntTooMany++
+			}
+		}
+		return cntTimeout, cntTooMany
+	}
+	numOfGoroutinesBefore := runtime.NumGoroutine()
+	if cntTimeout, cntTooMany := checkTokens(false); cntTimeout != 2 || cntTooMany != 4 {
+		t.Errorf("The tokens should be held until the inner function returns. timeout: %d, too many: %d",
+			cntTimeout, cntTooMany)
+	}
+	if cntTimeout, cntTooMany := checkTokens(true); cntTimeout != 6 || cntTooMany != 0 {
+		t.Errorf("The tokens should be returned on timeout. timeout: %d, too many: %d",
+			cntTimeout, cntTooMany)
+	}
+	if n := waitForGoroutines(numOfGoroutinesBefore, time.Millisecond*300); n > numOfGoroutinesBefore {
+		t.Errorf("The inner functions are still running. Goroutines before: %d, after: %d",
+			numOfGoroutinesBefore, n)
+	}
+}
+
+func TestCircuitBreakWithConfigStorage(t *testing.T) {
+	storage := createMemoryConfigStorage(map[string]string{
+		"circuit_break": `{"Timeout": 1000, "MaxCurrentRequests": 4}`,
+	})
+	cbDec, err := CreateCircuitBreakDecorator().
+		WithConfigStorage(storage, "circuit_break", time.Millisecond*10).
+		Build()
+	checkUnexpectedError(err, t)
+	release := make(chan struct{})
+	decoratedFn := cbDec.DecorateContext(func(ctx context.Context, req Request) (Response, error) {
+		<-release
+		return req, nil
+	})
+	respChan := make(chan fnResponse, 10)
+	call := func(n int) {
+		for i := 0; i < n; i++ {
+			go func() {
+				ret, err := decoratedFn(context.Background(), 10)
+				respChan <- fnResponse{ret, err}
+			}()
+		}
+	}
+	waitForInFlight := func(expected int) {
+		deadline := time.Now().Add(time.Second)
+		for cbDec.tokens.inFlight() != expected && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		checkMaxCurReq(expected, cbDec.tokens.inFlight(), t)
+	}
+	call(4)
+	waitForInFlight(4)
+
+	// shrink the limit, the in-flight requests are kept
+	storage.Set("circuit_break", `{"Timeout": 1000, "MaxCurrentRequests": 2}`)
+	time.Sleep(time.Millisecond * 50)
+	if cbDec.loadSettings().maxCurrentRequests != 2 {
+		t.Error("The max concurrency setting is expected to be updated.")
+	}
+	if _, err := decoratedFn(context.Background(), 10); err != ErrorCircuitBreakTooManyConcurrentRequests {
+		t.Errorf("ErrorCircuitBreakTooManyConcurrentRequests is expected, but the actual is %v", err)
+	}
+	waitForInFlight(4)
+
+	// grow the limit
+	storage.Set("circuit_break", `{"Timeout": 1000, "MaxCurrentRequests": 6}`)
+	time.Sleep(time.Millisecond * 50)
+	call(2)
+	waitForInFlight(6)
+	if _, err := decoratedFn(context.Background(), 10); err != ErrorCircuitBreakTooManyConcurrentRequests {
+		t.Errorf("ErrorCircuitBreakTooManyConcurrentRequests is expected, but the actual is %v", err)
+	}
+
+	// the invalid config is ignored
+	storage.Set("circuit_break", `{"Timeout": 0, "MaxCurrentRequests": 100}`)
+	time.Sleep(time.Millisecond * 50)
+	checkMaxCurReq(6, cbDec.loadSettings().maxCurrentRequests, t)
+
+	close(release)
+	for i := 0; i < 6; i++ {
+		if resp := <-respChan; resp.err != nil {
+			t.Errorf("Unexpected error happened %v", resp.err)
+		}
+	}
+	waitForInFlight(0)
+}
+
+func TestCircuitBreakTimeoutWithConfigStorage(t *testing.T) {
+	storage := createMemoryConfigStorage(map[string]string{
+		"circuit_break": `{"Timeout": 2000}`,
+	})
+	cbDec, err := CreateCircuitBreakDecorator().
+		WithConfigStorage(storage, "circuit_break", time.Millisecond*10).
+		Build()
+	checkUnexpectedError(err, t)
+	checkTimeoutSetting(time.Second*2, cbDec.loadSettings().timeout, t)
+	storage.Set("circuit_break", `{"Timeout": 5}`)
+	time.Sleep(time.Millisecond * 50)
+	decoratedFn := cbDec.DecorateContext(mockContextAwareLongRunFn)
+	if _, err := decoratedFn(context.Background(), 10); err != ErrorCircuitBreakTimeout {
+		t.Errorf("ErrorCircuitBreakTimeout is expected, but the actual is %v", err)
+	}
+}
+
+// enqueueWaiters is to start the waiters one by one in the order of the ids,
+// the id is sent to the returned channel when the waiter gets the token.
+func enqueueWaiters(tokens *concurrencyTokens, ids []int) chan int {
+	served := make(chan int, len(ids))
+	for i, id := range ids {
+		go func(id int) {
+			if tokens.get(context.Background(), time.Second) {
+				served <- id
+			}
+		}(id)
+		for tokens.queueLength() != i+1 {
+			runtime.Gosched()
+		}
+	}
+	return served
+}
+
+func checkServedOrder(tokens *concurrencyTokens, served chan int, expected []int, t *testing.T) {
+	t.Helper()
+	for _, id := range expected {
+		tokens.release()
+		if actual := <-served; actual != id {
+			t.Errorf("The waiter %d is expected to be served, but the actual is %d", id, actual)
+		}
+	}
+}
+
+func TestConcurrencyTokensQueueOrder(t *testing.T) {
+	fifo := createConcurrencyTokens(1, 3, FIFOQueue)
+	fifo.get(context.Background(), 0)
+	checkServedOrder(fifo, enqueueWaiters(fifo, []int{1, 2, 3}), []int{1, 2, 3}, t)
+
+	lifo := createConcurrencyTokens(1, 3, LIFOQueue)
+	lifo.get(context.Background(), 0)
+	checkServedOrder(lifo, enqueueWaiters(lifo, []int{1, 2, 3}), []int{3, 2, 1}, t)
+}
+
+func TestConcurrencyTokensQueueWakenByRaisingLimit(t *testing.T) {
+	tokens := createConcurrencyTokens(1, 2, FIFOQueue)
+	tokens.get(context.Background(), 0)
+	served := enqueueWaiters(tokens, []int{1, 2})
+	tokens.setLimit(3)
+	<-served
+	<-served
+	if inFlight := tokens.inFlight(); inFlight != 3 {
+		t.Errorf("The in-flight requests are expected to be 3, but the actual is %d", inFlight)
+	}
+}
+
+func TestCircuitBreakWithQueue(t *testing.T) {
+	dec, err := CreateCircuitBreakDecorator().
+		WithTimeout(time.Second).
+		WithMaxCurrentRequests(1).
+		WithQueue(1, time.Millisecond*200, FIFOQueue).
+		Build()
+	checkErr(err, t)
+	release := make(chan struct{})
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		if req == "blocking" {
+			<-release
+		}
+		return req, nil
+	})
+	go decFn("blocking")
+	for dec.tokens.inFlight() != 1 {
+		runtime.Gosched()
+	}
+	queued := make(chan error, 1)
+	go func() {
+		_, err := decFn("queued")
+		queued <- err
+	}()
+	for dec.tokens.queueLength() != 1 {
+		runtime.Gosched()
+	}
+	if _, err := decFn("rejected"); err != ErrorCircuitBreakTooManyConcurrentRequests {
+		t.Errorf("The request is expected to be rejected when the queue is full, but the actual is %v", err)
+	}
+	close(release)
+	checkErr(<-queued, t)
+
+	block := make(chan struct{})
+	defer close(block)
+	decFn = dec.Decorate(func(req Request) (Response, error) {
+		<-block
+		return req, nil
+	})
+	go decFn("blocking")
+	for dec.tokens.inFlight() != 1 {
+		runtime.Gosched()
+	}
+	start := time.Now()
+	if _, err := decFn("timeout"); err != ErrorCircuitBreakTooManyConcurrentRequests {
+		t.Errorf("ErrorCircuitBreakTooManyConcurrentRequests is expected after waiting, but the actual is %v", err)
+	}
+	if waited := time.Since(start); waited < time.Millisecond*200 {
+		t.Errorf("The request is expected to wait for 200ms, but the actual is %v", waited)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err = dec.DecorateContext(ToContextServiceFunc(MockServiceFn))(ctx, 1)
+	if err != context.DeadlineExceeded {
+		t.Errorf("The context's error is expected, but the actual is %v", err)
+	}
+	if queueLength := dec.tokens.queueLength(); queueLength != 0 {
+		t.Errorf("The queue is expected to be empty, but the actual length is %d", queueLength)
+	}
+}
+
+func TestCircuitBreakWithInvalidQueue(t *testing.T) {
+	_, err := CreateCircuitBreakDecorator().WithQueue(10, 0, FIFOQueue).Build()
+	if err == nil {
+		t.Error("The error is expected for the invalid queue setting.")
+	}
+}
@@ -0,0 +1,121 @@
+package service_decorators
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrorCircuitBreakerForcedOpen happens when the circuit breaker is forced to be open
+var ErrorCircuitBreakerForcedOpen = errors.New("the circuit breaker is forced open")
+
+// CircuitBreakerOverride is the manual override of the circuit breakers,
+// which is used during the incidents and the maintenance.
+type CircuitBreakerOverride int32
+
+const (
+	// NoOverride lets the circuit breaker work as usual
+	NoOverride CircuitBreakerOverride = iota
+	// ForceOpen processes all the requests by the fallback function
+	ForceOpen
+	// ForceClosed passes all the requests to the backend,
+	// the results are still counted in the metrics
+	ForceClosed
+	// Disabled passes all the requests to the backend as if the circuit breaker doesn't exist
+	Disabled
+)
+
+var circuitBreakerOverrideNames = map[CircuitBreakerOverride]string{
+	NoOverride:  "NONE",
+	ForceOpen:   "FORCE_OPEN",
+	ForceClosed: "FORCE_CLOSED",
+	Disabled:    "DISABLED",
+}
+
+func (override CircuitBreakerOverride) String() string {
+	if name, ok := circuitBreakerOverrideNames[override]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// ParseCircuitBreakerOverride is to get the override by the name (NONE, FORCE_OPEN, FORCE_CLOSED or DISABLED),
+// "" means NONE.
+func ParseCircuitBreakerOverride(name string) (CircuitBreakerOverride, error) {
+	if name == "" {
+		return NoOverride, nil
+	}
+	for override, overrideName := range circuitBreakerOverrideNames {
+		if overrideName == name {
+			return override, nil
+		}
+	}
+	return NoOverride, fmt.Errorf("unknown circuit breaker override %q", name)
+}
+
+// CircuitBreakerOverrideConfig is the override configuration stored in ConfigStorage.
+type CircuitBreakerOverrideConfig struct {
+	Override string `json:"Override"` //NONE, FORCE_OPEN, FORCE_CLOSED or DISABLED
+}
+
+func getCircuitBreakerOverrideFromStorage(configStorage ConfigStorage,
+	configName string) (CircuitBreakerOverride, error) {
+	configStr, err := configStorage.Get(configName)
+	if err != nil {
+		return NoOverride, err
+	}
+	config := CircuitBreakerOverrideConfig{}
+	if err = json.Unmarshal(configStr, &config); err != nil {
+		return NoOverride, err
+	}
+	return ParseCircuitBreakerOverride(config.Override)
+}
+
+// overrideSwitch holds the override set by the code or the configuration storage
+type overrideSwitch struct {
+	value int32
+	// the override last read from the storage
+	lock           sync.Mutex
+	storedOverride CircuitBreakerOverride
+}
+
+func (overrides *overrideSwitch) get() CircuitBreakerOverride {
+	return CircuitBreakerOverride(atomic.LoadInt32(&overrides.value))
+}
+
+func (overrides *overrideSwitch) set(override CircuitBreakerOverride) {
+	atomic.StoreInt32(&overrides.value, int32(override))
+}
+
+// load is to apply the override in the storage when it has been changed,
+// so the override set by the code is kept until the configuration is changed.
+func (overrides *overrideSwitch) load(configStorage ConfigStorage, configName string) {
+	override, err := getCircuitBreakerOverrideFromStorage(configStorage, configName)
+	if err != nil {
+		return
+	}
+	overrides.lock.Lock()
+	defer overrides.lock.Unlock()
+	if override != overrides.storedOverride {
+		overrides.storedOverride = override
+		overrides.set(override)
+	}
+}
+
+// watch is to load the override from the storage, and refresh it periodically.
+// The invalid configurations are ignored.
+func (overrides *overrideSwitch) watch(configStorage ConfigStorage, configName string,
+	refreshInterval time.Duration) {
+	overrides.load(configStorage, configName)
+	if refreshInterval <= 0 {
+		return
+	}
+	go func() {
+		for _ = range time.Tick(refreshInterval) {
+			overrides.load(configStorage, configName)
+		}
+	}()
+}
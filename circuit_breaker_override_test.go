@@ -0,0 +1,125 @@
+package service_decorators
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseCircuitBreakerOverride(t *testing.T) {
+	for _, override := range []CircuitBreakerOverride{NoOverride, ForceOpen, ForceClosed, Disabled} {
+		parsed, err := ParseCircuitBreakerOverride(override.String())
+		if err != nil || parsed != override {
+			t.Errorf("%v is expected, but the actual is %v, %v", override, parsed, err)
+		}
+	}
+	if _, err := ParseCircuitBreakerOverride("OPEN"); err == nil {
+		t.Error("The error is expected for the unknown override.")
+	}
+}
+
+func TestOverrideSwitchWithConfigStorage(t *testing.T) {
+	storage := createMemoryConfigStorage(map[string]string{
+		"override": `{"Override": "FORCE_OPEN"}`,
+	})
+	overrides := &overrideSwitch{}
+	overrides.watch(storage, "override", 0)
+	if override := overrides.get(); override != ForceOpen {
+		t.Errorf("FORCE_OPEN is expected, but the actual is %v", override)
+	}
+	// the override set by the code is kept until the configuration is changed
+	overrides.set(Disabled)
+	overrides.load(storage, "override")
+	if override := overrides.get(); override != Disabled {
+		t.Errorf("DISABLED is expected, but the actual is %v", override)
+	}
+	storage.Set("override", `{"Override": "INVALID"}`)
+	overrides.load(storage, "override")
+	if override := overrides.get(); override != Disabled {
+		t.Errorf("The invalid configuration is expected to be ignored, but the actual is %v", override)
+	}
+	storage.Set("override", `{"Override": "NONE"}`)
+	overrides.load(storage, "override")
+	if override := overrides.get(); override != NoOverride {
+		t.Errorf("NONE is expected, but the actual is %v", override)
+	}
+}
+
+func TestAdvancedCircuitBreakWithOverride(t *testing.T) {
+	var fallbackErr error
+	dec := CreateAdvancedCircuitBreakDecorator(1, time.Second, time.Second,
+		func(err error) bool { return true },
+		func(req Request, lastErr error) (Response, error) {
+			fallbackErr = lastErr
+			return "fallback", nil
+		})
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		if err, ok := req.(error); ok {
+			return nil, err
+		}
+		return req, nil
+	})
+	dec.SetOverride(ForceOpen)
+	if resp, _ := decFn("backend"); resp != "fallback" || fallbackErr != ErrorCircuitBreakerForcedOpen {
+		t.Errorf("The request is expected to be processed by the fallback, but the actual is %v, %v",
+			resp, fallbackErr)
+	}
+	dec.SetOverride(NoOverride)
+	tripAdvancedCircuitBreaker(decFn, 1)
+	dec.SetOverride(ForceClosed)
+	if resp, _ := decFn("backend"); resp != "backend" {
+		t.Errorf("The request is expected to be passed to the backend, but the actual is %v", resp)
+	}
+	dec.SetOverride(Disabled)
+	if resp, _ := decFn("backend"); resp != "backend" {
+		t.Errorf("The request is expected to be passed to the backend, but the actual is %v", resp)
+	}
+	dec.SetOverride(NoOverride)
+	if resp, _ := decFn("backend"); resp != "fallback" {
+		t.Errorf("The circuit breaker is expected to be still open, but the actual is %v", resp)
+	}
+	metrics := dec.Metrics()
+	if metrics.NumOfRejections != 2 || metrics.NumOfSuccesses != 1 || metrics.NumOfFailures != 1 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+}
+
+func TestCircuitBreakWithOverride(t *testing.T) {
+	storage := createMemoryConfigStorage(map[string]string{
+		"override": `{"Override": "FORCE_OPEN"}`,
+	})
+	dec, err := CreateCircuitBreakDecorator().
+		WithTimeout(time.Millisecond*50).
+		WithMaxCurrentRequests(1).
+		WithOverrideConfigStorage(storage, "override", time.Millisecond*10).
+		Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		if d, ok := req.(time.Duration); ok {
+			time.Sleep(d)
+		}
+		return req, nil
+	})
+	if _, err := decFn(1); err != ErrorCircuitBreakerForcedOpen {
+		t.Errorf("ErrorCircuitBreakerForcedOpen is expected, but the actual is %v", err)
+	}
+	storage.Set("override", `{"Override": "FORCE_CLOSED"}`)
+	time.Sleep(time.Millisecond * 50)
+	// the max concurrency is not limited
+	dec.tokens.get(context.Background(), 0)
+	if _, err := decFn(1); err != nil {
+		t.Errorf("The request is expected to be passed, but the actual is %v", err)
+	}
+	if _, err := decFn(time.Millisecond * 100); err != ErrorCircuitBreakTimeout {
+		t.Errorf("ErrorCircuitBreakTimeout is expected, but the actual is %v", err)
+	}
+	dec.SetOverride(Disabled)
+	if _, err := decFn(time.Millisecond * 100); err != nil {
+		t.Errorf("The timeout is not expected when disabled, but the actual is %v", err)
+	}
+	dec.SetOverride(NoOverride)
+	if _, err := decFn(1); !errors.Is(err, ErrorCircuitBreakTooManyConcurrentRequests) {
+		t.Errorf("ErrorCircuitBreakTooManyConcurrentRequests is expected, but the actual is %v", err)
+	}
+}
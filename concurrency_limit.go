@@ -0,0 +1,213 @@
+package service_decorators
+
+import (
+	"math"
+	"time"
+)
+
+// ConcurrencyLimitAlgorithm is the interface of the algorithms used by AdaptiveConcurrencyDecorator
+// to find the concurrency limit from the observed latency.
+// The methods are called by the decorator serially, so the implementations needn't be thread-safe.
+type ConcurrencyLimitAlgorithm interface {
+	// Limit is to get the current concurrency limit
+	Limit() int
+	// Update is to adjust the limit with the sample of a request.
+	// rtt: the latency of the request
+	// inFlight: the number of the in-flight requests when the request started, including itself
+	// dropped: whether the request failed because of the overload (e.g. timeout)
+	Update(rtt time.Duration, inFlight int, dropped bool)
+}
+
+// validatedConcurrencyLimit is implemented by the algorithms checked by AdaptiveConcurrencyDecoratorConfig.Build
+type validatedConcurrencyLimit interface {
+	isValid() bool
+}
+
+// concurrencyLimitRange keeps the limit in [minLimit, maxLimit]
+type concurrencyLimitRange struct {
+	limit        float64
+	initialLimit int
+	minLimit     int
+	maxLimit     int
+}
+
+// createConcurrencyLimitRange is to create the range, minLimit less than 1 is taken as 1.
+// The range is not clamped, the inverted one is rejected by AdaptiveConcurrencyDecoratorConfig.Build.
+func createConcurrencyLimitRange(initialLimit, minLimit, maxLimit int) concurrencyLimitRange {
+	if minLimit < 1 {
+		minLimit = 1
+	}
+	limitRange := concurrencyLimitRange{
+		initialLimit: initialLimit,
+		minLimit:     minLimit,
+		maxLimit:     maxLimit,
+	}
+	limitRange.setLimit(float64(initialLimit))
+	return limitRange
+}
+
+// isValid requires minLimit <= initialLimit <= maxLimit,
+// otherwise the limit would be pinned to the unexpected value silently.
+func (limitRange *concurrencyLimitRange) isValid() bool {
+	return limitRange.minLimit <= limitRange.initialLimit && limitRange.initialLimit <= limitRange.maxLimit
+}
+
+func (limitRange *concurrencyLimitRange) setLimit(limit float64) {
+	limitRange.limit = math.Max(float64(limitRange.minLimit),
+		math.Min(float64(limitRange.maxLimit), limit))
+}
+
+// Limit is to get the current concurrency limit
+func (limitRange *concurrencyLimitRange) Limit() int {
+	return int(limitRange.limit)
+}
+
+// isAppLimited is true when the in-flight requests are far below the limit,
+// the samples can't tell whether the limit could be increased.
+func (limitRange *concurrencyLimitRange) isAppLimited(inFlight int) bool {
+	return float64(inFlight)*2 < limitRange.limit
+}
+
+// AIMDLimit is the additive increase/multiplicative decrease algorithm.
+// The limit is increased by 1 for each successful request,
+// and multiplied by the backoff ratio when the request is dropped or its latency is beyond the timeout.
+type AIMDLimit struct {
+	concurrencyLimitRange
+	backoffRatio float64
+	timeout      time.Duration
+}
+
+// CreateAIMDLimit is to create AIMDLimit.
+// The backoff ratio is 0.9 by default, and the timeout is not checked by default.
+func CreateAIMDLimit(initialLimit, minLimit, maxLimit int) *AIMDLimit {
+	return &AIMDLimit{
+		concurrencyLimitRange: createConcurrencyLimitRange(initialLimit, minLimit, maxLimit),
+		backoffRatio:          0.9,
+	}
+}
+
+// WithBackoffRatio sets the ratio of decreasing the limit, which should be in (0, 1)
+func (algorithm *AIMDLimit) WithBackoffRatio(backoffRatio float64) *AIMDLimit {
+	algorithm.backoffRatio = backoffRatio
+	return algorithm
+}
+
+// WithTimeout sets the latency beyond which the request is regarded as dropped
+func (algorithm *AIMDLimit) WithTimeout(timeout time.Duration) *AIMDLimit {
+	algorithm.timeout = timeout
+	return algorithm
+}
+
+// Update is to adjust the limit with the sample of a request
+func (algorithm *AIMDLimit) Update(rtt time.Duration, inFlight int, dropped bool) {
+	if dropped || (algorithm.timeout > 0 && rtt > algorithm.timeout) {
+		algorithm.setLimit(math.Floor(algorithm.limit * algorithm.backoffRatio))
+		return
+	}
+	if !algorithm.isAppLimited(inFlight) {
+		algorithm.setLimit(algorithm.limit + 1)
+	}
+}
+
+// VegasLimit is the delay based algorithm inspired by TCP Vegas.
+// The queue size is estimated by limit * (1 - minRTT / rtt), where minRTT is the latency without load.
+// The limit is increased when the queue is short, and decreased when the queue is long.
+type VegasLimit struct {
+	concurrencyLimitRange
+	minRTT time.Duration
+}
+
+// CreateVegasLimit is to create VegasLimit
+func CreateVegasLimit(initialLimit, minLimit, maxLimit int) *VegasLimit {
+	return &VegasLimit{
+		concurrencyLimitRange: createConcurrencyLimitRange(initialLimit, minLimit, maxLimit),
+	}
+}
+
+// vegasStep is the step of changing the limit, which is log10(limit) and at least 1
+func vegasStep(limit float64) float64 {
+	return math.Max(1, math.Log10(limit))
+}
+
+// Update is to adjust the limit with the sample of a request
+func (algorithm *VegasLimit) Update(rtt time.Duration, inFlight int, dropped bool) {
+	limit := algorithm.limit
+	if dropped {
+		algorithm.setLimit(limit - vegasStep(limit))
+		return
+	}
+	if rtt <= 0 {
+		return
+	}
+	if algorithm.minRTT == 0 || rtt < algorithm.minRTT {
+		algorithm.minRTT = rtt
+	}
+	if algorithm.isAppLimited(inFlight) {
+		return
+	}
+	queueSize := math.Ceil(limit * (1 - float64(algorithm.minRTT)/float64(rtt)))
+	step := vegasStep(limit)
+	alpha, beta := 3*step, 6*step
+	switch {
+	case queueSize <= step:
+		algorithm.setLimit(limit + beta)
+	case queueSize < alpha:
+		algorithm.setLimit(limit + step)
+	case queueSize > beta:
+		algorithm.setLimit(limit - step)
+	}
+}
+
+// GradientLimit is the algorithm adjusting the limit by the gradient of the latency,
+// which is the ratio of the long-term average latency to the latest latency.
+// The limit shrinks when the latency increases, and grows by a small queue size when the latency is stable.
+type GradientLimit struct {
+	concurrencyLimitRange
+	longRTT   float64
+	smoothing float64
+	tolerance float64
+}
+
+const (
+	gradientLongWindow = 600
+	gradientQueueSize  = 4
+	gradientMinimum    = 0.5
+)
+
+// CreateGradientLimit is to create GradientLimit.
+// The limit is smoothed with the factor 0.2, and the latency up to 1.5 times of the average is tolerated.
+func CreateGradientLimit(initialLimit, minLimit, maxLimit int) *GradientLimit {
+	return &GradientLimit{
+		concurrencyLimitRange: createConcurrencyLimitRange(initialLimit, minLimit, maxLimit),
+		smoothing:             0.2,
+		tolerance:             1.5,
+	}
+}
+
+// Update is to adjust the limit with the sample of a request
+func (algorithm *GradientLimit) Update(rtt time.Duration, inFlight int, dropped bool) {
+	limit := algorithm.limit
+	gradient := gradientMinimum
+	if !dropped {
+		if rtt <= 0 {
+			return
+		}
+		shortRTT := float64(rtt)
+		if algorithm.longRTT == 0 {
+			algorithm.longRTT = shortRTT
+		} else {
+			algorithm.longRTT += (shortRTT - algorithm.longRTT) / gradientLongWindow
+		}
+		// speed up the recovery of the long-term average after the latency drops
+		if algorithm.longRTT/shortRTT > 2 {
+			algorithm.longRTT *= 0.95
+		}
+		if algorithm.isAppLimited(inFlight) {
+			return
+		}
+		gradient = math.Max(gradientMinimum,
+			math.Min(1, algorithm.tolerance*algorithm.longRTT/shortRTT))
+	}
+	newLimit := limit*gradient + gradientQueueSize
+	algorithm.setLimit(limit*(1-algorithm.smoothing) + newLimit*algorithm.smoothing)
+}
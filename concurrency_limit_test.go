@@ -0,0 +1,64 @@
+package service_decorators
+
+import (
+	"testing"
+	"time"
+)
+
+func checkLimit(algorithm ConcurrencyLimitAlgorithm, expected int, t *testing.T) {
+	t.Helper()
+	if limit := algorithm.Limit(); limit != expected {
+		t.Errorf("The limit is expected to be %d, but the actual is %d", expected, limit)
+	}
+}
+
+func TestAIMDLimit(t *testing.T) {
+	algorithm := CreateAIMDLimit(10, 2, 12).WithTimeout(time.Second)
+	algorithm.Update(time.Millisecond*10, 2, false)
+	checkLimit(algorithm, 10, t)
+	algorithm.Update(time.Millisecond*10, 10, false)
+	checkLimit(algorithm, 11, t)
+	algorithm.Update(time.Millisecond*10, 11, false)
+	algorithm.Update(time.Millisecond*10, 12, false)
+	checkLimit(algorithm, 12, t)
+	algorithm.Update(time.Millisecond*10, 12, true)
+	checkLimit(algorithm, 10, t)
+	algorithm.WithBackoffRatio(0.5).Update(time.Second*2, 10, false)
+	checkLimit(algorithm, 5, t)
+	algorithm.Update(time.Millisecond*10, 5, true)
+	algorithm.Update(time.Millisecond*10, 5, true)
+	checkLimit(algorithm, 2, t)
+}
+
+func TestVegasLimit(t *testing.T) {
+	algorithm := CreateVegasLimit(10, 1, 100)
+	algorithm.Update(time.Millisecond*10, 10, false)
+	checkLimit(algorithm, 16, t)
+	algorithm.Update(time.Millisecond*10, 2, false)
+	checkLimit(algorithm, 16, t)
+	// queue size = 16 * (1 - 10/40) = 12 > beta
+	algorithm.Update(time.Millisecond*40, 16, false)
+	checkLimit(algorithm, 14, t)
+	algorithm.Update(time.Millisecond*10, 16, true)
+	checkLimit(algorithm, 13, t)
+}
+
+func TestGradientLimit(t *testing.T) {
+	algorithm := CreateGradientLimit(20, 1, 100)
+	for i := 0; i < 10; i++ {
+		algorithm.Update(time.Millisecond*10, 20, false)
+	}
+	checkLimit(algorithm, 28, t)
+	for i := 0; i < 10; i++ {
+		algorithm.Update(time.Millisecond*100, 28, false)
+	}
+	if limit := algorithm.Limit(); limit >= 28 {
+		t.Errorf("The limit is expected to shrink when the latency increases, but the actual is %d", limit)
+	}
+	limit := algorithm.limit
+	algorithm.Update(time.Millisecond*10, 1, true)
+	if algorithm.limit >= limit {
+		t.Errorf("The limit is expected to shrink when the request is dropped, but the actual is %v",
+			algorithm.limit)
+	}
+}
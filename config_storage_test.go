@@ -2,7 +2,9 @@ package service_decorators
 
 import (
 	"encoding/json"
+	"errors"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/hashicorp/consul/api"
@@ -37,3 +39,29 @@ func TestGetAndSetForConsul(t *testing.T) {
 	}
 	t.Logf("%v", config)
 }
+
+// memoryConfigStorage is the thread-safe ConfigStorage for testing
+type memoryConfigStorage struct {
+	lock    sync.RWMutex
+	configs map[string]string
+}
+
+func createMemoryConfigStorage(configs map[string]string) *memoryConfigStorage {
+	return &memoryConfigStorage{configs: configs}
+}
+
+func (storage *memoryConfigStorage) Get(name string) ([]byte, error) {
+	storage.lock.RLock()
+	defer storage.lock.RUnlock()
+	v, ok := storage.configs[name]
+	if !ok {
+		return nil, errors.New("config not found")
+	}
+	return []byte(v), nil
+}
+
+func (storage *memoryConfigStorage) Set(name string, value string) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.configs[name] = value
+}
@@ -0,0 +1,62 @@
+package service_decorators
+
+import "context"
+
+// ToContextServiceFunc adapts the ServiceFunc to ContextServiceFunc.
+// The context is ignored by the adapted function.
+func ToContextServiceFunc(fn ServiceFunc) ContextServiceFunc {
+	return func(ctx context.Context, req Request) (Response, error) {
+		return fn(req)
+	}
+}
+
+// ToServiceFunc adapts the ContextServiceFunc to ServiceFunc.
+// context.Background() is passed to the adapted function.
+func ToServiceFunc(fn ContextServiceFunc) ServiceFunc {
+	return func(req Request) (Response, error) {
+		return fn(context.Background(), req)
+	}
+}
+
+type contextDecoratorAdapter struct {
+	dec Decorator
+}
+
+// DecorateContext is to thread the context of each invoking through the adapted Decorator
+func (adapter *contextDecoratorAdapter) DecorateContext(innerFn ContextServiceFunc) ContextServiceFunc {
+	return func(ctx context.Context, req Request) (Response, error) {
+		return adapter.dec.Decorate(func(r Request) (Response, error) {
+			return innerFn(ctx, r)
+		})(req)
+	}
+}
+
+type decoratorAdapter struct {
+	dec ContextDecorator
+}
+
+// Decorate is to decorate the ServiceFunc with the adapted ContextDecorator
+func (adapter *decoratorAdapter) Decorate(innerFn ServiceFunc) ServiceFunc {
+	return ToServiceFunc(adapter.dec.DecorateContext(ToContextServiceFunc(innerFn)))
+}
+
+// AsContextDecorator adapts the Decorator to ContextDecorator.
+// If the decorator has implemented ContextDecorator, it is returned directly.
+// Otherwise, the context of each invoking is passed through the adapted decorator
+// to the inner function, so the decorator is expected to keep its states
+// in the decorator instance instead of the decorated function.
+func AsContextDecorator(dec Decorator) ContextDecorator {
+	if ctxDec, ok := dec.(ContextDecorator); ok {
+		return ctxDec
+	}
+	return &contextDecoratorAdapter{dec}
+}
+
+// AsDecorator adapts the ContextDecorator to Decorator.
+// If the decorator has implemented Decorator, it is returned directly.
+func AsDecorator(dec ContextDecorator) Decorator {
+	if d, ok := dec.(Decorator); ok {
+		return d
+	}
+	return &decoratorAdapter{dec}
+}
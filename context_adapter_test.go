@@ -0,0 +1,88 @@
+package service_decorators
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type ctxKey string
+
+func readCtxValueFn(ctx context.Context, req Request) (Response, error) {
+	return ctx.Value(ctxKey("trace_id")), nil
+}
+
+func TestContextFlowsThroughBuiltinDecorators(t *testing.T) {
+	retryDec, err := CreateRetryDecorator(1, time.Millisecond, 0, retriableChecker)
+	checkErr(err, t)
+	rateLimitDec, err := CreateRateLimitDecorator(time.Second, 100, 100)
+	checkErr(err, t)
+	cbDec, err := CreateCircuitBreakDecorator().WithMaxCurrentRequests(10).Build()
+	checkErr(err, t)
+	advCbDec := CreateAdvancedCircuitBreakDecorator(3, time.Second, time.Second,
+		func(err error) bool { return true }, MockFallbackFn)
+	metricDec, err := CreateMetricDecorator(&memoryMet{}).NeedsRecordingTimeSpent().Build()
+	checkErr(err, t)
+	chaosDec, err := CreateChaosEngineeringDecorator(
+		&MockConfigStorage{ConfigStr: `{"IsToInjectChaos":false}`}, "chaos_config", nil, 0)
+	checkErr(err, t)
+	decs := []ContextDecorator{retryDec, rateLimitDec, cbDec, advCbDec, metricDec, chaosDec,
+		AsContextDecorator(createDemoDecorator())}
+	decFn := ContextServiceFunc(readCtxValueFn)
+	for _, dec := range decs {
+		decFn = dec.DecorateContext(decFn)
+	}
+	ctx := context.WithValue(context.Background(), ctxKey("trace_id"), "abc")
+	ret, err := decFn(ctx, 1)
+	checkErr(err, t)
+	if ret != "abc" {
+		t.Errorf("The context value is expected to be passed, but the actual is %v", ret)
+	}
+}
+
+func TestRetryIsInterruptedByContext(t *testing.T) {
+	retryDec, err := CreateRetryDecorator(3, time.Second*1, time.Second*1, retriableChecker)
+	checkErr(err, t)
+	decFn := retryDec.DecorateContext(func(ctx context.Context, req Request) (Response, error) {
+		return nil, ErrorConnection
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	_, err = decFn(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("context.DeadlineExceeded is expected, but the actual is %v", err)
+	}
+	if time.Since(start) > time.Millisecond*500 {
+		t.Error("The retry sleep should be interrupted by the context.")
+	}
+}
+
+func TestCircuitBreakReturnsWhenContextDone(t *testing.T) {
+	cbDec, err := CreateCircuitBreakDecorator().WithTimeout(time.Second * 5).Build()
+	checkErr(err, t)
+	decFn := cbDec.DecorateContext(ToContextServiceFunc(MockServiceLongRunFn))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = decFn(ctx, 10)
+	if err != context.Canceled {
+		t.Errorf("context.Canceled is expected, but the actual is %v", err)
+	}
+}
+
+func TestAdaptersBetweenServiceFuncAndContextServiceFunc(t *testing.T) {
+	fn := ToServiceFunc(ToContextServiceFunc(MockServiceFn))
+	ret, err := fn(10)
+	checkInnerFunc(ret, err, t)
+
+	dec := AsDecorator(AsContextDecorator(createDemoDecorator()))
+	ret, err = dec.Decorate(MockServiceFn)(10)
+	checkInnerFunc(ret, err, t)
+
+	rateLimitDec, err := CreateRateLimitDecorator(time.Second, 1, 1)
+	checkErr(err, t)
+	if AsContextDecorator(rateLimitDec) != ContextDecorator(rateLimitDec) {
+		t.Error("The prebuilt decorator should be used as ContextDecorator directly.")
+	}
+}
@@ -0,0 +1,232 @@
+package service_decorators
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// ErrorCounterStoreConflict happens when the counter can't be updated
+// because of too many concurrent updates
+var ErrorCounterStoreConflict = errors.New("too many conflicts when updating the counter")
+
+// CounterStore is the storage of the counters shared by the service instances,
+// it is used by DistributedRateLimiter.
+// The implementations should be thread-safe.
+type CounterStore interface {
+	// IncrWithinLimit is to increase the counter by delta atomically
+	// when the increased value is not beyond the limit, otherwise the counter is not changed.
+	// The counter is created with the expiry when it doesn't exist or has been expired.
+	// It returns whether the counter is increased and the remaining time to live of the counter.
+	IncrWithinLimit(key string, delta int64, limit int64, expiry time.Duration) (bool, time.Duration, error)
+}
+
+type expiringCounter struct {
+	count    int64
+	expireAt time.Time
+}
+
+// MemoryCounterStore is the CounterStore in local memory,
+// which is for testing and the single instance service.
+type MemoryCounterStore struct {
+	lock      sync.Mutex
+	counters  map[string]*expiringCounter
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+// CreateMemoryCounterStore is to create MemoryCounterStore
+func CreateMemoryCounterStore() *MemoryCounterStore {
+	return &MemoryCounterStore{
+		counters: map[string]*expiringCounter{},
+		now:      time.Now,
+	}
+}
+
+// IncrWithinLimit is to increase the counter by delta atomically when it is not beyond the limit
+func (store *MemoryCounterStore) IncrWithinLimit(key string, delta int64, limit int64,
+	expiry time.Duration) (bool, time.Duration, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	now := store.now()
+	store.sweep(now, expiry)
+	counter, ok := store.counters[key]
+	if !ok || !now.Before(counter.expireAt) {
+		if delta > limit {
+			return false, 0, nil
+		}
+		counter = &expiringCounter{expireAt: now.Add(expiry)}
+		store.counters[key] = counter
+	}
+	ttl := counter.expireAt.Sub(now)
+	if counter.count+delta > limit {
+		return false, ttl, nil
+	}
+	counter.count += delta
+	return true, ttl, nil
+}
+
+// sweep is to remove the expired counters, it runs at most once per expiry
+func (store *MemoryCounterStore) sweep(now time.Time, expiry time.Duration) {
+	if now.Before(store.nextSweep) {
+		return
+	}
+	for key, counter := range store.counters {
+		if !now.Before(counter.expireAt) {
+			delete(store.counters, key)
+		}
+	}
+	store.nextSweep = now.Add(expiry)
+}
+
+const maxCASRetries = 10
+
+// consulCounter is the value of the counter stored in Consul KV
+type consulCounter struct {
+	Count    int64 `json:"Count"`
+	ExpireAt int64 `json:"ExpireAt"` //The expiry time (unix milliseconds)
+}
+
+func (counter consulCounter) expireAt() time.Time {
+	return time.Unix(0, counter.ExpireAt*int64(time.Millisecond))
+}
+
+// consulKV is the part of Consul KV API used by ConsulCounterStore
+type consulKV interface {
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+	DeleteCAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+}
+
+// ConsulCounterStore is the CounterStore with Consul KV.
+// The counters are updated with check-and-set, and the expiry is based on
+// the local time of the service instances, so the clocks of the instances should be synchronized.
+// Consul KV doesn't expire the keys, so the expired counters written by the store
+// are deleted in background at most once per expiry.
+type ConsulCounterStore struct {
+	client consulKV
+	now    func() time.Time
+
+	lock       sync.Mutex
+	expireAts  map[string]time.Time // the expiry of the counters written by the store
+	nextSweep  time.Time
+	isSweeping bool
+}
+
+// CreateConsulCounterStore is to create a ConsulCounterStore
+func CreateConsulCounterStore(consulConfig *api.Config) (*ConsulCounterStore, error) {
+	client, err := api.NewClient(consulConfig)
+	if err != nil {
+		return nil, err
+	}
+	return createConsulCounterStore(client.KV()), nil
+}
+
+func createConsulCounterStore(client consulKV) *ConsulCounterStore {
+	return &ConsulCounterStore{
+		client:    client,
+		now:       time.Now,
+		expireAts: map[string]time.Time{},
+	}
+}
+
+// getCounter is to get the counter and its modify index, the index is 0 when the key doesn't exist.
+func (store *ConsulCounterStore) getCounter(key string) (consulCounter, uint64, error) {
+	counter := consulCounter{}
+	pair, _, err := store.client.Get(key, nil)
+	if err != nil || pair == nil {
+		return counter, 0, err
+	}
+	if json.Unmarshal(pair.Value, &counter) != nil {
+		counter = consulCounter{}
+	}
+	return counter, pair.ModifyIndex, nil
+}
+
+// IncrWithinLimit is to increase the counter by delta atomically when it is not beyond the limit.
+// The limit is checked before the check-and-set, so the rejected increment doesn't write to Consul.
+// ErrorCounterStoreConflict would be returned when the check-and-set keeps failing.
+func (store *ConsulCounterStore) IncrWithinLimit(key string, delta int64, limit int64,
+	expiry time.Duration) (bool, time.Duration, error) {
+	for i := 0; i < maxCASRetries; i++ {
+		counter, modifyIndex, err := store.getCounter(key)
+		if err != nil {
+			return false, 0, err
+		}
+		now := store.now()
+		expireAt := counter.expireAt()
+		if !now.Before(expireAt) {
+			if delta > limit {
+				return false, 0, nil
+			}
+			expireAt = now.Add(expiry)
+			counter = consulCounter{ExpireAt: expireAt.UnixNano() / int64(time.Millisecond)}
+			expireAt = counter.expireAt()
+		}
+		if counter.Count+delta > limit {
+			return false, expireAt.Sub(now), nil
+		}
+		counter.Count += delta
+		value, err := json.Marshal(counter)
+		if err != nil {
+			return false, 0, err
+		}
+		ok, _, err := store.client.CAS(&api.KVPair{
+			Key:         key,
+			Value:       value,
+			ModifyIndex: modifyIndex,
+		}, nil)
+		if err != nil {
+			return false, 0, err
+		}
+		if ok {
+			store.track(key, expireAt, now, expiry)
+			return true, expireAt.Sub(now), nil
+		}
+	}
+	return false, 0, ErrorCounterStoreConflict
+}
+
+// track is to record the expiry of the counter,
+// and start deleting the expired counters at most once per expiry.
+func (store *ConsulCounterStore) track(key string, expireAt time.Time, now time.Time, expiry time.Duration) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.expireAts[key] = expireAt
+	if store.isSweeping || now.Before(store.nextSweep) {
+		return
+	}
+	var expiredKeys []string
+	for k, t := range store.expireAts {
+		if !now.Before(t) {
+			expiredKeys = append(expiredKeys, k)
+			delete(store.expireAts, k)
+		}
+	}
+	store.nextSweep = now.Add(expiry)
+	if len(expiredKeys) == 0 {
+		return
+	}
+	store.isSweeping = true
+	go store.deleteExpiredCounters(expiredKeys)
+}
+
+// deleteExpiredCounters is to delete the counters which are still expired in Consul,
+// the counters renewed by the other instances are kept by check-and-delete.
+func (store *ConsulCounterStore) deleteExpiredCounters(keys []string) {
+	defer func() {
+		store.lock.Lock()
+		store.isSweeping = false
+		store.lock.Unlock()
+	}()
+	for _, key := range keys {
+		counter, modifyIndex, err := store.getCounter(key)
+		if err != nil || modifyIndex == 0 || store.now().Before(counter.expireAt()) {
+			continue
+		}
+		store.client.DeleteCAS(&api.KVPair{Key: key, ModifyIndex: modifyIndex}, nil)
+	}
+}
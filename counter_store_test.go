@@ -0,0 +1,178 @@
+package service_decorators
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func checkIncr(store CounterStore, key string, delta int64, limit int64, expiry time.Duration,
+	expectedOK bool, expectedTTL time.Duration, t *testing.T) {
+	t.Helper()
+	ok, ttl, err := store.IncrWithinLimit(key, delta, limit, expiry)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if ok != expectedOK || ttl != expectedTTL {
+		t.Errorf("Increasing %s by %d within %d: expected (%v, %v), but the actual is (%v, %v)",
+			key, delta, limit, expectedOK, expectedTTL, ok, ttl)
+	}
+}
+
+func TestMemoryCounterStore(t *testing.T) {
+	clock := &fakeClock{now: testStartTime}
+	store := CreateMemoryCounterStore()
+	store.now = clock.Now
+	checkIncr(store, "a", 2, 5, time.Second, true, time.Second, t)
+	clock.Sleep(context.Background(), time.Millisecond*300)
+	checkIncr(store, "a", 3, 5, time.Second, true, time.Millisecond*700, t)
+	checkIncr(store, "a", 1, 5, time.Second, false, time.Millisecond*700, t)
+	checkCnt(int(store.counters["a"].count), 5, t)
+	checkIncr(store, "b", 6, 5, time.Second, false, 0, t)
+	checkIncr(store, "b", 1, 5, time.Second, true, time.Second, t)
+	clock.Sleep(context.Background(), time.Millisecond*700)
+	checkIncr(store, "a", 1, 5, time.Second, true, time.Second, t)
+	clock.Sleep(context.Background(), time.Second*2)
+	checkIncr(store, "c", 1, 5, time.Second, true, time.Second, t)
+	if len(store.counters) != 1 {
+		t.Errorf("The expired counters are expected to be removed, but the actual counters are %v",
+			store.counters)
+	}
+}
+
+func TestMemoryCounterStoreConcurrently(t *testing.T) {
+	store := CreateMemoryCounterStore()
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	numOfIncreased := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _, _ := store.IncrWithinLimit("a", 1, 30, time.Minute); ok {
+				lock.Lock()
+				numOfIncreased++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	checkCnt(numOfIncreased, 30, t)
+	checkCnt(int(store.counters["a"].count), 30, t)
+}
+
+// fakeConsulKV is the Consul KV in local memory with the check-and-set semantics
+type fakeConsulKV struct {
+	lock      sync.Mutex
+	pairs     map[string]api.KVPair
+	lastIndex uint64
+	numOfCAS  int
+}
+
+func createFakeConsulKV() *fakeConsulKV {
+	return &fakeConsulKV{pairs: map[string]api.KVPair{}}
+}
+
+func (kv *fakeConsulKV) Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	pair, ok := kv.pairs[key]
+	if !ok {
+		return nil, nil, nil
+	}
+	return &pair, nil, nil
+}
+
+func (kv *fakeConsulKV) CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	kv.numOfCAS++
+	if kv.pairs[p.Key].ModifyIndex != p.ModifyIndex {
+		return false, nil, nil
+	}
+	kv.lastIndex++
+	kv.pairs[p.Key] = api.KVPair{Key: p.Key, Value: p.Value, ModifyIndex: kv.lastIndex}
+	return true, nil, nil
+}
+
+func (kv *fakeConsulKV) DeleteCAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	pair, ok := kv.pairs[p.Key]
+	if !ok || pair.ModifyIndex != p.ModifyIndex {
+		return false, nil, nil
+	}
+	delete(kv.pairs, p.Key)
+	return true, nil, nil
+}
+
+func (kv *fakeConsulKV) has(key string) bool {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	_, ok := kv.pairs[key]
+	return ok
+}
+
+func TestConsulCounterStoreDoesNotWriteRejectedIncrements(t *testing.T) {
+	kv := createFakeConsulKV()
+	store := createConsulCounterStore(kv)
+	clock := &fakeClock{now: testStartTime}
+	store.now = clock.Now
+	checkIncr(store, "a", 2, 3, time.Second, true, time.Second, t)
+	clock.Sleep(context.Background(), time.Millisecond*400)
+	checkIncr(store, "a", 2, 3, time.Second, false, time.Millisecond*600, t)
+	checkIncr(store, "a", 1, 3, time.Second, true, time.Millisecond*600, t)
+	checkCnt(kv.numOfCAS, 2, t)
+	clock.Sleep(context.Background(), time.Millisecond*600)
+	checkIncr(store, "a", 3, 3, time.Second, true, time.Second, t)
+}
+
+func TestConsulCounterStoreRemovesExpiredCounters(t *testing.T) {
+	kv := createFakeConsulKV()
+	store := createConsulCounterStore(kv)
+	clock := &fakeClock{now: testStartTime}
+	store.now = clock.Now
+	checkIncr(store, "a", 1, 3, time.Second, true, time.Second, t)
+	checkIncr(store, "b", 1, 3, time.Second, true, time.Second, t)
+	clock.Sleep(context.Background(), time.Millisecond*500)
+	checkIncr(store, "b", 1, 3, time.Second, true, time.Millisecond*500, t)
+	clock.Sleep(context.Background(), time.Millisecond*600)
+	checkIncr(store, "c", 1, 3, time.Second, true, time.Second, t)
+	for i := 0; i < 100 && kv.has("a"); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if kv.has("a") || kv.has("b") {
+		t.Error("The expired counters are expected to be removed")
+	}
+	if !kv.has("c") {
+		t.Error("The counter not expired is expected to be kept")
+	}
+}
+
+func TestIncrForConsul(t *testing.T) {
+	store, err := CreateConsulCounterStore(&api.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "CounterStoreTest"
+	ok, ttl, err := store.IncrWithinLimit(key, 1, 1000000, time.Second)
+	if err != nil {
+		if strings.Contains(err.Error(), "connection") {
+			t.Log("Warning: The failure is caused by Consul connection.")
+			return
+		}
+		t.Fatal(err)
+	}
+	if !ok || ttl <= 0 || ttl > time.Second {
+		t.Errorf("Unexpected result %v with TTL %v", ok, ttl)
+	}
+	if ok, _, err = store.IncrWithinLimit(key, 1000001, 1000000, time.Second); err != nil || ok {
+		t.Errorf("The increment beyond the limit is expected to be rejected, but the actual is %v, %v",
+			ok, err)
+	}
+}
@@ -0,0 +1,633 @@
+package service_decorators
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrorDecoratorNotRegistered occurred when the decorator factory can't be found in the registry
+var ErrorDecoratorNotRegistered = errors.New("the decorator is not registered")
+
+// ErrorFunctionNotRegistered occurred when the fallback/checker/service function can't be found in the registry
+var ErrorFunctionNotRegistered = errors.New("the function is not registered")
+
+// ChainSpec is the declarative definition of the decorators chain.
+// The first decorator is the outermost one, e.g.
+//
+//	{
+//	  "Decorators": [
+//	    {"Name": "rate_limit", "Params": {"Interval": 1000, "NumOfRequests": 100, "TokenBucketSize": 100}},
+//	    {"Name": "circuit_break", "Params": {"Timeout": 100, "MaxCurrentRequests": 10, "TimeoutFallback": "cached"}},
+//	    {"Name": "retry", "Params": {"MaxRetryTimes": 3, "RetryInterval": 10, "RetriableChecker": "conn_err"}}
+//	  ]
+//	}
+type ChainSpec struct {
+	Decorators []DecoratorSpec `json:"Decorators" yaml:"Decorators"`
+}
+
+// DecoratorSpec is the declarative definition of a decorator in the chain
+type DecoratorSpec struct {
+	// Name is the name of the registered DecoratorFactory
+	Name   string          `json:"Name" yaml:"Name"`
+	Params DecoratorParams `json:"Params" yaml:"Params"`
+}
+
+// DecoratorParams is the parameters of the decorator.
+// All the time durations are in milliseconds.
+type DecoratorParams map[string]interface{}
+
+// Decode is to decode the parameters into the struct v.
+// The unknown parameters would cause the error.
+func (params DecoratorParams) Decode(v interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// DecoratorFactory is to create the decorator with the parameters.
+// The functions (e.g. fallback function) referenced by the name in the parameters
+// can be got from the registry.
+type DecoratorFactory func(params DecoratorParams, registry *DecoratorRegistry) (Decorator, error)
+
+// DecoratorRegistry is to register the decorator factories and the functions referenced by the chain spec.
+// The prebuilt factories are "rate_limit", "circuit_break", "advanced_circuit_break",
+// "adaptive_concurrency", "retry" and "chaos".
+type DecoratorRegistry struct {
+	lock            sync.RWMutex
+	factories       map[string]DecoratorFactory
+	fallbackFns     map[string]ServiceFallbackFunc
+	errorCheckers   map[string]func(err error) bool
+	serviceFns      map[string]ServiceFunc
+	configStorage   ConfigStorage
+	specUnmarshaler func(data []byte, v interface{}) error
+}
+
+// CreateDecoratorRegistry is to create a DecoratorRegistry with the prebuilt decorator factories
+func CreateDecoratorRegistry() *DecoratorRegistry {
+	registry := &DecoratorRegistry{
+		factories:       map[string]DecoratorFactory{},
+		fallbackFns:     map[string]ServiceFallbackFunc{},
+		errorCheckers:   map[string]func(err error) bool{},
+		serviceFns:      map[string]ServiceFunc{},
+		specUnmarshaler: json.Unmarshal,
+	}
+	registry.RegisterFactory("rate_limit", rateLimitDecoratorFactory).
+		RegisterFactory("circuit_break", circuitBreakDecoratorFactory).
+		RegisterFactory("advanced_circuit_break", advancedCircuitBreakDecoratorFactory).
+		RegisterFactory("adaptive_concurrency", adaptiveConcurrencyDecoratorFactory).
+		RegisterFactory("retry", retryDecoratorFactory).
+		RegisterFactory("chaos", chaosEngineeringDecoratorFactory)
+	return registry
+}
+
+// RegisterFactory is to register the decorator factory with the name
+func (registry *DecoratorRegistry) RegisterFactory(name string, factory DecoratorFactory) *DecoratorRegistry {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.factories[name] = factory
+	return registry
+}
+
+// RegisterFallbackFunction is to register the fallback function with the name
+func (registry *DecoratorRegistry) RegisterFallbackFunction(name string, fn ServiceFallbackFunc) *DecoratorRegistry {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.fallbackFns[name] = fn
+	return registry
+}
+
+// RegisterErrorChecker is to register the error checker with the name,
+// such as the retriable checker of RetryDecorator and the ErrorDistinguisher of AdvancedCircuitBreakDecorator
+func (registry *DecoratorRegistry) RegisterErrorChecker(name string, checker func(err error) bool) *DecoratorRegistry {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.errorCheckers[name] = checker
+	return registry
+}
+
+// RegisterServiceFunction is to register the service function with the name,
+// such as the chaos response function of ChaosEngineeringDecorator
+func (registry *DecoratorRegistry) RegisterServiceFunction(name string, fn ServiceFunc) *DecoratorRegistry {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.serviceFns[name] = fn
+	return registry
+}
+
+// WithConfigStorage is to set the storage used by the decorators
+// which read their configurations from ConfigStorage (e.g. ChaosEngineeringDecorator)
+func (registry *DecoratorRegistry) WithConfigStorage(storage ConfigStorage) *DecoratorRegistry {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.configStorage = storage
+	return registry
+}
+
+// WithSpecUnmarshaler is to set the unmarshaler of the chain spec document, JSON is the default one.
+// To use YAML, set it as yaml.Unmarshal (e.g. gopkg.in/yaml.v3).
+func (registry *DecoratorRegistry) WithSpecUnmarshaler(
+	unmarshaler func(data []byte, v interface{}) error) *DecoratorRegistry {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.specUnmarshaler = unmarshaler
+	return registry
+}
+
+// ConfigStorage is to get the storage set by WithConfigStorage
+func (registry *DecoratorRegistry) ConfigStorage() ConfigStorage {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	return registry.configStorage
+}
+
+// FallbackFunction is to get the registered fallback function.
+// The empty name means no fallback function, nil is returned.
+func (registry *DecoratorRegistry) FallbackFunction(name string) (ServiceFallbackFunc, error) {
+	if name == "" {
+		return nil, nil
+	}
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	fn, ok := registry.fallbackFns[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: fallback function %q", ErrorFunctionNotRegistered, name)
+	}
+	return fn, nil
+}
+
+// ErrorChecker is to get the registered error checker.
+// The empty name means no error checker, nil is returned.
+func (registry *DecoratorRegistry) ErrorChecker(name string) (func(err error) bool, error) {
+	if name == "" {
+		return nil, nil
+	}
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	checker, ok := registry.errorCheckers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: error checker %q", ErrorFunctionNotRegistered, name)
+	}
+	return checker, nil
+}
+
+// ServiceFunction is to get the registered service function.
+// The empty name means no service function, nil is returned.
+func (registry *DecoratorRegistry) ServiceFunction(name string) (ServiceFunc, error) {
+	if name == "" {
+		return nil, nil
+	}
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	fn, ok := registry.serviceFns[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: service function %q", ErrorFunctionNotRegistered, name)
+	}
+	return fn, nil
+}
+
+// ParseChainSpec is to parse the chain spec document
+func (registry *DecoratorRegistry) ParseChainSpec(doc []byte) (*ChainSpec, error) {
+	registry.lock.RLock()
+	unmarshal := registry.specUnmarshaler
+	registry.lock.RUnlock()
+	spec := ChainSpec{}
+	if err := unmarshal(doc, &spec); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// CreateChain is to create the decorators and compose them into the Chain according to the spec
+func (registry *DecoratorRegistry) CreateChain(spec *ChainSpec) (*Chain, error) {
+	chain := CreateChain()
+	for i, decSpec := range spec.Decorators {
+		registry.lock.RLock()
+		factory, ok := registry.factories[decSpec.Name]
+		registry.lock.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrorDecoratorNotRegistered, decSpec.Name)
+		}
+		dec, err := factory(decSpec.Params, registry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create decorator [%d] %q: %w", i, decSpec.Name, err)
+		}
+		chain.Append(dec)
+	}
+	return chain, nil
+}
+
+// LoadChain is to create the Chain according to the spec document stored in the storage
+func (registry *DecoratorRegistry) LoadChain(storage ConfigStorage, specName string) (*Chain, error) {
+	doc, err := storage.Get(specName)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := registry.ParseChainSpec(doc)
+	if err != nil {
+		return nil, err
+	}
+	return registry.CreateChain(spec)
+}
+
+// BuildFromConfigStorage is to decorate the service function with the chain
+// defined by the spec document stored in the storage
+func (registry *DecoratorRegistry) BuildFromConfigStorage(storage ConfigStorage,
+	specName string, fn ServiceFunc) (ServiceFunc, error) {
+	chain, err := registry.LoadChain(storage, specName)
+	if err != nil {
+		return nil, err
+	}
+	return chain.Build(fn)
+}
+
+func millisecond(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+var rateLimitWaitModes = map[string]RateLimitWaitMode{
+	"":                    RejectWhenBeyondRateLimit,
+	"reject":              RejectWhenBeyondRateLimit,
+	"wait":                WaitForToken,
+	"wait_with_max_delay": WaitForTokenWithMaxDelay,
+}
+
+var rateLimitAlgorithms = map[string]RateLimitAlgorithm{
+	"":                       TokenBucket,
+	"token_bucket":           TokenBucket,
+	"fixed_window":           FixedWindow,
+	"sliding_window_log":     SlidingWindowLog,
+	"sliding_window_counter": SlidingWindowCounter,
+	"leaky_bucket":           LeakyBucket,
+	"gcra":                   GCRA,
+}
+
+func rateLimitDecoratorFactory(params DecoratorParams, registry *DecoratorRegistry) (Decorator, error) {
+	// the rate settings are read from the config storage when ConfigName is set
+	settings := struct {
+		RateLimitConfig
+		Algorithm       string
+		WaitMode        string
+		MaxWaitTime     int
+		Fallback        string
+		ConfigName      string
+		RefreshInterval int
+	}{}
+	if err := params.Decode(&settings); err != nil {
+		return nil, err
+	}
+	waitMode, ok := rateLimitWaitModes[settings.WaitMode]
+	if !ok {
+		return nil, fmt.Errorf("unknown wait mode %q", settings.WaitMode)
+	}
+	algorithm, ok := rateLimitAlgorithms[settings.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown rate limit algorithm %q", settings.Algorithm)
+	}
+	fallbackFn, err := registry.FallbackFunction(settings.Fallback)
+	if err != nil {
+		return nil, err
+	}
+	config := CreateRateLimitDecoratorConfig(millisecond(settings.Interval),
+		settings.NumOfRequests, settings.TokenBucketSize).
+		WithAlgorithm(algorithm).
+		WithWaitMode(waitMode).
+		WithMaxWaitTime(millisecond(settings.MaxWaitTime)).
+		WithFallbackFunction(fallbackFn)
+	if settings.ConfigName != "" {
+		storage := registry.ConfigStorage()
+		if storage == nil {
+			return nil, errors.New("the config storage of the registry is required")
+		}
+		config.WithConfigStorage(storage, settings.ConfigName, millisecond(settings.RefreshInterval))
+	}
+	dec, err := config.Build()
+	if err != nil {
+		return nil, err
+	}
+	return dec, nil
+}
+
+var queueOrders = map[string]QueueOrder{
+	"":     FIFOQueue,
+	"fifo": FIFOQueue,
+	"lifo": LIFOQueue,
+}
+
+func circuitBreakDecoratorFactory(params DecoratorParams, registry *DecoratorRegistry) (Decorator, error) {
+	settings := struct {
+		Timeout                      int
+		MaxCurrentRequests           int
+		TimeoutFallback              string
+		BeyondMaxConcurrencyFallback string
+		ReleaseTokenOnTimeout        bool
+		MaxQueueLength               int
+		MaxQueueTime                 int
+		QueueOrder                   string
+		ConfigName                   string
+		RefreshInterval              int
+		OverrideConfigName           string
+		OverrideRefreshInterval      int
+	}{}
+	if err := params.Decode(&settings); err != nil {
+		return nil, err
+	}
+	queueOrder, ok := queueOrders[settings.QueueOrder]
+	if !ok {
+		return nil, fmt.Errorf("unknown queue order %q", settings.QueueOrder)
+	}
+	config := CreateCircuitBreakDecorator().
+		WithMaxCurrentRequests(settings.MaxCurrentRequests).
+		WithQueue(settings.MaxQueueLength, millisecond(settings.MaxQueueTime), queueOrder)
+	if settings.ConfigName != "" {
+		storage := registry.ConfigStorage()
+		if storage == nil {
+			return nil, errors.New("the config storage of the registry is required")
+		}
+		config.WithConfigStorage(storage, settings.ConfigName, millisecond(settings.RefreshInterval))
+	}
+	if settings.OverrideConfigName != "" {
+		storage := registry.ConfigStorage()
+		if storage == nil {
+			return nil, errors.New("the config storage of the registry is required")
+		}
+		config.WithOverrideConfigStorage(storage, settings.OverrideConfigName,
+			millisecond(settings.OverrideRefreshInterval))
+	}
+	if settings.Timeout > 0 {
+		config.WithTimeout(millisecond(settings.Timeout))
+	}
+	if settings.ReleaseTokenOnTimeout {
+		config.WithReleaseTokenOnTimeout()
+	}
+	timeoutFallbackFn, err := registry.FallbackFunction(settings.TimeoutFallback)
+	if err != nil {
+		return nil, err
+	}
+	beyondMaxConcurrencyFallbackFn, err := registry.FallbackFunction(settings.BeyondMaxConcurrencyFallback)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := config.WithTimeoutFallbackFunction(timeoutFallbackFn).
+		WithBeyondMaxConcurrencyFallbackFunction(beyondMaxConcurrencyFallbackFn).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	return dec, nil
+}
+
+var slidingWindowTypes = map[string]SlidingWindowType{
+	"":      CountBasedSlidingWindow,
+	"count": CountBasedSlidingWindow,
+	"time":  TimeBasedSlidingWindow,
+}
+
+func advancedCircuitBreakDecoratorFactory(params DecoratorParams, registry *DecoratorRegistry) (Decorator, error) {
+	settings := struct {
+		ErrorFrequencyThreshold     int64
+		ResetIntervalOfErrorCounter int
+		BackendRetryInterval        int
+		HalfOpenMaxProbes           int
+		HalfOpenSuccessThreshold    int
+		SlidingWindowType           string
+		SlidingWindowSize           int
+		MinimumNumberOfCalls        int
+		FailureRateThreshold        float64
+		SlowCallRateThreshold       float64
+		SlowCallDuration            int
+		OverrideConfigName          string
+		OverrideRefreshInterval     int
+		ErrorDistinguisher          string
+		Fallback                    string
+	}{}
+	if err := params.Decode(&settings); err != nil {
+		return nil, err
+	}
+	errDistinguisher, err := registry.ErrorChecker(settings.ErrorDistinguisher)
+	if err != nil {
+		return nil, err
+	}
+	if errDistinguisher == nil {
+		errDistinguisher = func(err error) bool { return true }
+	}
+	fallbackFn, err := registry.FallbackFunction(settings.Fallback)
+	if err != nil {
+		return nil, err
+	}
+	if fallbackFn == nil {
+		return nil, errors.New("the fallback function is required")
+	}
+	dec := CreateAdvancedCircuitBreakDecorator(settings.ErrorFrequencyThreshold,
+		millisecond(settings.ResetIntervalOfErrorCounter),
+		millisecond(settings.BackendRetryInterval),
+		errDistinguisher, fallbackFn)
+	if settings.HalfOpenMaxProbes != 0 || settings.HalfOpenSuccessThreshold != 0 {
+		if settings.HalfOpenMaxProbes <= 0 || settings.HalfOpenSuccessThreshold <= 0 {
+			return nil, errors.New("both HalfOpenMaxProbes and HalfOpenSuccessThreshold should be positive")
+		}
+		dec.WithHalfOpenProbes(settings.HalfOpenMaxProbes, settings.HalfOpenSuccessThreshold)
+	}
+	if settings.SlidingWindowSize > 0 {
+		windowType, ok := slidingWindowTypes[settings.SlidingWindowType]
+		if !ok {
+			return nil, fmt.Errorf("unknown sliding window type %q", settings.SlidingWindowType)
+		}
+		dec.WithSlidingWindow(windowType, settings.SlidingWindowSize, settings.MinimumNumberOfCalls)
+	}
+	if settings.FailureRateThreshold > 0 {
+		dec.WithFailureRateThreshold(settings.FailureRateThreshold)
+	}
+	if settings.SlowCallRateThreshold > 0 {
+		dec.WithSlowCallRateThreshold(settings.SlowCallRateThreshold, millisecond(settings.SlowCallDuration))
+	}
+	if settings.OverrideConfigName != "" {
+		storage := registry.ConfigStorage()
+		if storage == nil {
+			return nil, errors.New("the config storage of the registry is required")
+		}
+		dec.WithOverrideConfigStorage(storage, settings.OverrideConfigName,
+			millisecond(settings.OverrideRefreshInterval))
+	}
+	return dec, nil
+}
+
+// concurrencyLimitAlgorithms creates the algorithms by the names, AIMD is used by default
+var concurrencyLimitAlgorithms = map[string]func(initialLimit, minLimit, maxLimit int) ConcurrencyLimitAlgorithm{
+	"": func(initialLimit, minLimit, maxLimit int) ConcurrencyLimitAlgorithm {
+		return CreateAIMDLimit(initialLimit, minLimit, maxLimit)
+	},
+	"aimd": func(initialLimit, minLimit, maxLimit int) ConcurrencyLimitAlgorithm {
+		return CreateAIMDLimit(initialLimit, minLimit, maxLimit)
+	},
+	"vegas": func(initialLimit, minLimit, maxLimit int) ConcurrencyLimitAlgorithm {
+		return CreateVegasLimit(initialLimit, minLimit, maxLimit)
+	},
+	"gradient": func(initialLimit, minLimit, maxLimit int) ConcurrencyLimitAlgorithm {
+		return CreateGradientLimit(initialLimit, minLimit, maxLimit)
+	},
+}
+
+func adaptiveConcurrencyDecoratorFactory(params DecoratorParams, registry *DecoratorRegistry) (Decorator, error) {
+	settings := struct {
+		Algorithm    string
+		InitialLimit int
+		MinLimit     int
+		MaxLimit     int
+		DropChecker  string
+		Fallback     string
+	}{}
+	if err := params.Decode(&settings); err != nil {
+		return nil, err
+	}
+	if settings.MaxLimit <= 0 {
+		return nil, fmt.Errorf("%w: MaxLimit is required", ErrorAdaptiveConcurrencyDecoratorConfig)
+	}
+	if settings.MaxLimit < settings.InitialLimit || settings.MaxLimit < settings.MinLimit {
+		return nil, fmt.Errorf("%w: MaxLimit should not be less than InitialLimit and MinLimit",
+			ErrorAdaptiveConcurrencyDecoratorConfig)
+	}
+	createAlgorithm, ok := concurrencyLimitAlgorithms[settings.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown concurrency limit algorithm %q", settings.Algorithm)
+	}
+	dropChecker, err := registry.ErrorChecker(settings.DropChecker)
+	if err != nil {
+		return nil, err
+	}
+	fallbackFn, err := registry.FallbackFunction(settings.Fallback)
+	if err != nil {
+		return nil, err
+	}
+	config := CreateAdaptiveConcurrencyDecoratorConfig(
+		createAlgorithm(settings.InitialLimit, settings.MinLimit, settings.MaxLimit)).
+		WithFallbackFunction(fallbackFn)
+	if dropChecker != nil {
+		config.WithDropChecker(dropChecker)
+	}
+	dec, err := config.Build()
+	if err != nil {
+		return nil, err
+	}
+	return dec, nil
+}
+
+// retryBackoffParams are the backoff settings of the retry decorator in the chain spec
+type retryBackoffParams struct {
+	RetryInterval     int // the base delay of the exponential and jitter backoffs
+	IntervalIncrement int
+	MaxInterval       int
+	Schedule          []int
+}
+
+var retryBackoffs = map[string]func(params retryBackoffParams) BackoffStrategy{
+	"": func(params retryBackoffParams) BackoffStrategy {
+		return CreateLinearBackoff(millisecond(params.RetryInterval), millisecond(params.IntervalIncrement))
+	},
+	"linear": func(params retryBackoffParams) BackoffStrategy {
+		return CreateLinearBackoff(millisecond(params.RetryInterval), millisecond(params.IntervalIncrement))
+	},
+	"exponential": func(params retryBackoffParams) BackoffStrategy {
+		return CreateExponentialBackoff(millisecond(params.RetryInterval), millisecond(params.MaxInterval))
+	},
+	"full_jitter": func(params retryBackoffParams) BackoffStrategy {
+		return CreateFullJitterBackoff(millisecond(params.RetryInterval), millisecond(params.MaxInterval))
+	},
+	"equal_jitter": func(params retryBackoffParams) BackoffStrategy {
+		return CreateEqualJitterBackoff(millisecond(params.RetryInterval), millisecond(params.MaxInterval))
+	},
+	"decorrelated_jitter": func(params retryBackoffParams) BackoffStrategy {
+		return CreateDecorrelatedJitterBackoff(millisecond(params.RetryInterval), millisecond(params.MaxInterval))
+	},
+	"fixed_schedule": func(params retryBackoffParams) BackoffStrategy {
+		schedule := make([]time.Duration, len(params.Schedule))
+		for i, delay := range params.Schedule {
+			schedule[i] = millisecond(delay)
+		}
+		return CreateFixedScheduleBackoff(schedule...)
+	},
+}
+
+func retryDecoratorFactory(params DecoratorParams, registry *DecoratorRegistry) (Decorator, error) {
+	settings := struct {
+		MaxRetryTimes int
+		retryBackoffParams
+		Backoff          string
+		RetriableChecker string
+		AttemptTimeout   int
+		MaxElapsedTime   int
+		// the retry budget is used when RetryBudgetWindow > 0
+		RetryBudgetPercentage float64
+		MinRetriesPerSecond   float64
+		RetryBudgetWindow     int
+	}{}
+	if err := params.Decode(&settings); err != nil {
+		return nil, err
+	}
+	retriableChecker, err := registry.ErrorChecker(settings.RetriableChecker)
+	if err != nil {
+		return nil, err
+	}
+	createBackoff, ok := retryBackoffs[settings.Backoff]
+	if !ok {
+		return nil, fmt.Errorf("unknown backoff %q", settings.Backoff)
+	}
+	if settings.Backoff != "fixed_schedule" && settings.RetryInterval <= 0 {
+		return nil, fmt.Errorf("%w: RetryInterval is required by the backoff %q",
+			ErrorRetryDecoratorConfig, settings.Backoff)
+	}
+	if settings.Backoff != "" && settings.Backoff != "linear" && settings.Backoff != "fixed_schedule" &&
+		settings.MaxInterval < settings.RetryInterval {
+		return nil, fmt.Errorf("%w: MaxInterval is required by the backoff %q and it should not be less than RetryInterval",
+			ErrorRetryDecoratorConfig, settings.Backoff)
+	}
+	config := CreateRetryDecoratorConfig(settings.MaxRetryTimes, retriableChecker).
+		WithBackoff(createBackoff(settings.retryBackoffParams)).
+		WithAttemptTimeout(millisecond(settings.AttemptTimeout)).
+		WithMaxElapsedTime(millisecond(settings.MaxElapsedTime))
+	if settings.RetryBudgetWindow > 0 {
+		budget, err := CreateRetryBudget(settings.RetryBudgetPercentage, settings.MinRetriesPerSecond,
+			millisecond(settings.RetryBudgetWindow))
+		if err != nil {
+			return nil, err
+		}
+		config.WithRetryBudget(budget)
+	}
+	dec, err := config.Build()
+	if err != nil {
+		return nil, err
+	}
+	return dec, nil
+}
+
+func chaosEngineeringDecoratorFactory(params DecoratorParams, registry *DecoratorRegistry) (Decorator, error) {
+	settings := struct {
+		ConfigName            string
+		RefreshInterval       int
+		ChaosResponseFunction string
+	}{}
+	if err := params.Decode(&settings); err != nil {
+		return nil, err
+	}
+	storage := registry.ConfigStorage()
+	if storage == nil {
+		return nil, errors.New("the config storage of the registry is required")
+	}
+	chaosResponseFn, err := registry.ServiceFunction(settings.ChaosResponseFunction)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := CreateChaosEngineeringDecorator(storage, settings.ConfigName,
+		chaosResponseFn, millisecond(settings.RefreshInterval))
+	if err != nil {
+		return nil, err
+	}
+	return dec, nil
+}
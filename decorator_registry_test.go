@@ -0,0 +1,155 @@
+package service_decorators
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBuildChainFromConfigStorage(t *testing.T) {
+	storage := createMemoryConfigStorage(map[string]string{
+		"chain": `{
+			"Decorators": [
+				{"Name": "rate_limit", "Params": {"Interval": 1000, "NumOfRequests": 100, "TokenBucketSize": 100}},
+				{"Name": "adaptive_concurrency", "Params": {"Algorithm": "vegas", "InitialLimit": 10, "MaxLimit": 100}},
+				{"Name": "circuit_break", "Params": {"Timeout": 5, "MaxCurrentRequests": 10, "MaxQueueLength": 10, "MaxQueueTime": 5, "TimeoutFallback": "fallback"}},
+				{"Name": "retry", "Params": {"MaxRetryTimes": 2, "RetryInterval": 1, "RetriableChecker": "conn_err"}},
+				{"Name": "chaos", "Params": {"ConfigName": "chaos"}}
+			]
+		}`,
+		"chaos": `{"IsToInjectChaos": false}`,
+	})
+	registry := CreateDecoratorRegistry().
+		WithConfigStorage(storage).
+		RegisterFallbackFunction("fallback", MockFallbackFn).
+		RegisterErrorChecker("conn_err", retriableChecker)
+	cntExecution := 0
+	decFn, err := registry.BuildFromConfigStorage(storage, "chain",
+		func(req Request) (Response, error) {
+			cntExecution++
+			if cntExecution < 2 {
+				return nil, ErrorConnection
+			}
+			return MockServiceFn(req)
+		})
+	checkErr(err, t)
+	ret, err := decFn(10)
+	checkInnerFunc(ret, err, t)
+	checkCnt(cntExecution, 2, t)
+
+	chain, err := registry.LoadChain(storage, "chain")
+	checkErr(err, t)
+	t.Log("\n" + chain.String())
+}
+
+func TestChainSpecWithUnregisteredNames(t *testing.T) {
+	registry := CreateDecoratorRegistry()
+	storage := createMemoryConfigStorage(map[string]string{
+		"unknown_decorator": `{"Decorators": [{"Name": "unknown"}]}`,
+		"unknown_fallback": `{"Decorators": [
+			{"Name": "circuit_break", "Params": {"TimeoutFallback": "unknown"}}]}`,
+		"unknown_param": `{"Decorators": [
+			{"Name": "circuit_break", "Params": {"Timeot": 100}}]}`,
+	})
+	if _, err := registry.LoadChain(storage, "unknown_decorator"); !errors.Is(err, ErrorDecoratorNotRegistered) {
+		t.Errorf("ErrorDecoratorNotRegistered is expected, but the actual is %v", err)
+	}
+	if _, err := registry.LoadChain(storage, "unknown_fallback"); !errors.Is(err, ErrorFunctionNotRegistered) {
+		t.Errorf("ErrorFunctionNotRegistered is expected, but the actual is %v", err)
+	}
+	if _, err := registry.LoadChain(storage, "unknown_param"); err == nil {
+		t.Error("The error is expected for the unknown parameter.")
+	}
+}
+
+func TestChainSpecWithInvalidOrder(t *testing.T) {
+	storage := createMemoryConfigStorage(map[string]string{
+		"chain": `{"Decorators": [
+			{"Name": "circuit_break"},
+			{"Name": "advanced_circuit_break", "Params": {"ErrorFrequencyThreshold": 3, "Fallback": "fallback",
+				"SlidingWindowType": "time", "SlidingWindowSize": 10, "FailureRateThreshold": 50}}
+		]}`,
+	})
+	registry := CreateDecoratorRegistry().RegisterFallbackFunction("fallback", MockFallbackFn)
+	_, err := registry.BuildFromConfigStorage(storage, "chain", MockServiceFn)
+	if !errors.Is(err, ErrorInvalidDecoratorsOrder) {
+		t.Errorf("ErrorInvalidDecoratorsOrder is expected, but the actual is %v", err)
+	}
+}
+
+func TestChainSpecWithRetryBackoff(t *testing.T) {
+	storage := createMemoryConfigStorage(map[string]string{
+		"jitter": `{"Decorators": [{"Name": "retry", "Params": {"MaxRetryTimes": 2,
+			"Backoff": "decorrelated_jitter", "RetryInterval": 1, "MaxInterval": 5, "RetriableChecker": "conn_err",
+			"AttemptTimeout": 100, "MaxElapsedTime": 1000}}]}`,
+		"schedule": `{"Decorators": [{"Name": "retry", "Params": {"MaxRetryTimes": 2,
+			"Backoff": "fixed_schedule", "Schedule": [1, 2], "RetriableChecker": "conn_err",
+			"RetryBudgetPercentage": 20, "MinRetriesPerSecond": 1, "RetryBudgetWindow": 10000}}]}`,
+		"unknown": `{"Decorators": [{"Name": "retry", "Params": {"MaxRetryTimes": 2,
+			"Backoff": "unknown", "RetriableChecker": "conn_err"}}]}`,
+		"without_max_interval": `{"Decorators": [{"Name": "retry", "Params": {"MaxRetryTimes": 2,
+			"Backoff": "full_jitter", "RetryInterval": 10, "RetriableChecker": "conn_err"}}]}`,
+		"without_interval": `{"Decorators": [{"Name": "retry", "Params": {"MaxRetryTimes": 2,
+			"Backoff": "exponential", "MaxInterval": 10, "RetriableChecker": "conn_err"}}]}`,
+	})
+	registry := CreateDecoratorRegistry().RegisterErrorChecker("conn_err", retriableChecker)
+	for _, specName := range []string{"jitter", "schedule"} {
+		cntExecution := 0
+		decFn, err := registry.BuildFromConfigStorage(storage, specName,
+			func(req Request) (Response, error) {
+				cntExecution++
+				return nil, ErrorConnection
+			})
+		checkErr(err, t)
+		decFn(1)
+		checkCnt(cntExecution, 3, t)
+	}
+	if _, err := registry.LoadChain(storage, "unknown"); err == nil {
+		t.Error("The error is expected for the unknown backoff.")
+	}
+	for _, specName := range []string{"without_max_interval", "without_interval"} {
+		if _, err := registry.LoadChain(storage, specName); !errors.Is(err, ErrorRetryDecoratorConfig) {
+			t.Errorf("ErrorRetryDecoratorConfig is expected for %s, but the actual is %v", specName, err)
+		}
+	}
+}
+
+func TestChainSpecWithDefaultConcurrencyLimitAlgorithm(t *testing.T) {
+	storage := createMemoryConfigStorage(map[string]string{
+		"chain": `{"Decorators": [{"Name": "adaptive_concurrency", "Params": {"InitialLimit": 10, "MaxLimit": 100}}]}`,
+	})
+	chain, err := CreateDecoratorRegistry().LoadChain(storage, "chain")
+	checkErr(err, t)
+	dec, ok := chain.decorators[0].(*AdaptiveConcurrencyDecorator)
+	if !ok {
+		t.Fatalf("AdaptiveConcurrencyDecorator is expected, but the actual is %T", chain.decorators[0])
+	}
+	if _, ok := dec.config.algorithm.(*AIMDLimit); !ok {
+		t.Errorf("AIMDLimit is expected by default, but the actual is %T", dec.config.algorithm)
+	}
+}
+
+func TestChainSpecWithInvalidHalfOpenProbes(t *testing.T) {
+	storage := createMemoryConfigStorage(map[string]string{
+		"chain": `{"Decorators": [{"Name": "advanced_circuit_break", "Params": {"ErrorFrequencyThreshold": 3,
+			"Fallback": "fallback", "HalfOpenMaxProbes": -1, "HalfOpenSuccessThreshold": -1}}]}`,
+	})
+	registry := CreateDecoratorRegistry().RegisterFallbackFunction("fallback", MockFallbackFn)
+	if _, err := registry.LoadChain(storage, "chain"); err == nil {
+		t.Error("The error is expected for the negative half open probes.")
+	}
+}
+
+func TestChainSpecWithInvalidConcurrencyLimitRange(t *testing.T) {
+	storage := createMemoryConfigStorage(map[string]string{
+		"without_max_limit": `{"Decorators": [{"Name": "adaptive_concurrency", "Params": {"InitialLimit": 20}}]}`,
+		"inverted_range": `{"Decorators": [{"Name": "adaptive_concurrency",
+			"Params": {"InitialLimit": 20, "MaxLimit": 10}}]}`,
+	})
+	for _, specName := range []string{"without_max_limit", "inverted_range"} {
+		if _, err := CreateDecoratorRegistry().LoadChain(storage, specName); !errors.Is(err,
+			ErrorAdaptiveConcurrencyDecoratorConfig) {
+			t.Errorf("ErrorAdaptiveConcurrencyDecoratorConfig is expected for %s, but the actual is %v",
+				specName, err)
+		}
+	}
+}
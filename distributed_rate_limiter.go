@@ -0,0 +1,92 @@
+package service_decorators
+
+import (
+	"sync"
+	"time"
+)
+
+// the interval of retrying the counter store after it failed
+const defaultCounterStoreRetryInterval = time.Second
+
+// DistributedRateLimiter limits the rate of all the service instances
+// with the fixed window counter in CounterStore.
+// When the counter store is unavailable, the local rate limiter is used instead
+// until the counter store is retried after a second.
+type DistributedRateLimiter struct {
+	lock          sync.Mutex
+	store         CounterStore
+	key           string
+	interval      time.Duration
+	numOfRequests int
+
+	localLimiter       RateLimiter
+	storeRetryInterval time.Duration
+	storeRetryTime     time.Time
+}
+
+// CreateDistributedRateLimiter is to create DistributedRateLimiter.
+// store: the counter store shared by the service instances
+// key: the key of the counter in the store
+// Rate = numOfRequests / interval, it is the rate of all the service instances.
+// localLimiter: the rate limiter used when the counter store is unavailable
+func CreateDistributedRateLimiter(store CounterStore, key string,
+	interval time.Duration, numOfRequests int, localLimiter RateLimiter) *DistributedRateLimiter {
+	return &DistributedRateLimiter{
+		store:              store,
+		key:                key,
+		interval:           interval,
+		numOfRequests:      numOfRequests,
+		localLimiter:       localLimiter,
+		storeRetryInterval: defaultCounterStoreRetryInterval,
+	}
+}
+
+// isStoreAvailable is to check whether the counter store should be used at the time now
+func (limiter *DistributedRateLimiter) isStoreAvailable(now time.Time) bool {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	return !now.Before(limiter.storeRetryTime)
+}
+
+func (limiter *DistributedRateLimiter) markStoreUnavailable(now time.Time) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	limiter.storeRetryTime = now.Add(limiter.storeRetryInterval)
+}
+
+func (limiter *DistributedRateLimiter) rate() (time.Duration, int) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	return limiter.interval, limiter.numOfRequests
+}
+
+// TryAcquire is to take n tokens at the time now.
+// The counter store is only increased when the tokens are taken.
+func (limiter *DistributedRateLimiter) TryAcquire(now time.Time, n int) (bool, time.Duration) {
+	interval, numOfRequests := limiter.rate()
+	if n > numOfRequests {
+		return false, -1
+	}
+	if !limiter.isStoreAvailable(now) {
+		return limiter.localLimiter.TryAcquire(now, n)
+	}
+	ok, ttl, err := limiter.store.IncrWithinLimit(limiter.key, int64(n), int64(numOfRequests), interval)
+	if err != nil {
+		limiter.markStoreUnavailable(now)
+		return limiter.localLimiter.TryAcquire(now, n)
+	}
+	if ok {
+		return true, 0
+	}
+	return false, ttl
+}
+
+// SetRate is to update the rate of the counter store and the local rate limiter,
+// the burst is only applied to the local rate limiter
+func (limiter *DistributedRateLimiter) SetRate(interval time.Duration, numOfRequests int, burst int) {
+	limiter.lock.Lock()
+	limiter.interval = interval
+	limiter.numOfRequests = numOfRequests
+	limiter.lock.Unlock()
+	limiter.localLimiter.SetRate(interval, numOfRequests, burst)
+}
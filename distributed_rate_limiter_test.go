@@ -0,0 +1,102 @@
+package service_decorators
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// unstableCounterStore fails when it is set to be unavailable
+type unstableCounterStore struct {
+	CounterStore
+	unavailable int32
+	numOfCalls  int32
+}
+
+func (store *unstableCounterStore) setAvailable(available bool) {
+	if available {
+		atomic.StoreInt32(&store.unavailable, 0)
+	} else {
+		atomic.StoreInt32(&store.unavailable, 1)
+	}
+}
+
+func (store *unstableCounterStore) IncrWithinLimit(key string, delta int64, limit int64,
+	expiry time.Duration) (bool, time.Duration, error) {
+	atomic.AddInt32(&store.numOfCalls, 1)
+	if atomic.LoadInt32(&store.unavailable) == 1 {
+		return false, 0, errors.New("the counter store is unavailable")
+	}
+	return store.CounterStore.IncrWithinLimit(key, delta, limit, expiry)
+}
+
+func createTestCounterStore(clock *fakeClock) *MemoryCounterStore {
+	store := CreateMemoryCounterStore()
+	store.now = clock.Now
+	return store
+}
+
+func TestDistributedRateLimiterSharesTheCounters(t *testing.T) {
+	clock := &fakeClock{now: testStartTime}
+	store := createTestCounterStore(clock)
+	limiter1 := CreateDistributedRateLimiter(store, "svc", time.Second, 3,
+		CreateTokenBucketRateLimiter(time.Second, 3, 3))
+	limiter2 := CreateDistributedRateLimiter(store, "svc", time.Second, 3,
+		CreateTokenBucketRateLimiter(time.Second, 3, 3))
+	checkAcquire(limiter1, clock.Now(), 2, true, 0, t)
+	checkAcquire(limiter2, clock.Now(), 2, false, time.Second, t)
+	checkAcquire(limiter2, clock.Now(), 1, true, 0, t)
+	checkAcquire(limiter1, clock.Now(), 4, false, -1, t)
+	clock.Sleep(context.Background(), time.Millisecond*400)
+	checkAcquire(limiter1, clock.Now(), 1, false, time.Millisecond*600, t)
+	clock.Sleep(context.Background(), time.Millisecond*600)
+	checkAcquire(limiter1, clock.Now(), 3, true, 0, t)
+	limiter2.SetRate(time.Second, 5, 5)
+	checkAcquire(limiter2, clock.Now(), 2, true, 0, t)
+}
+
+func TestDistributedRateLimiterFallsBackToLocalLimiter(t *testing.T) {
+	clock := &fakeClock{now: testStartTime}
+	store := &unstableCounterStore{CounterStore: createTestCounterStore(clock)}
+	limiter := CreateDistributedRateLimiter(store, "svc", time.Second, 3,
+		CreateFixedWindowRateLimiter(time.Second, 2))
+	store.setAvailable(false)
+	checkAcquire(limiter, clock.Now(), 1, true, 0, t)
+	checkAcquire(limiter, clock.Now(), 1, true, 0, t)
+	checkAcquire(limiter, clock.Now(), 1, false, time.Second, t)
+	if calls := atomic.LoadInt32(&store.numOfCalls); calls != 1 {
+		t.Errorf("The counter store is expected not to be called during the outage, "+
+			"but the number of calls is %d", calls)
+	}
+	store.setAvailable(true)
+	clock.Sleep(context.Background(), time.Second)
+	checkAcquire(limiter, clock.Now(), 3, true, 0, t)
+	checkAcquire(limiter, clock.Now(), 1, false, time.Second, t)
+}
+
+func TestRateLimitDecoratorWithCounterStore(t *testing.T) {
+	store := CreateMemoryCounterStore()
+	createDecFn := func() ServiceFunc {
+		dec, err := CreateRateLimitDecoratorConfig(time.Minute, 3, 3).
+			WithCounterStore(store, "rate_limit/").
+			WithKeyExtractor(tenantOf).Build()
+		checkErr(err, t)
+		return dec.Decorate(func(req Request) (Response, error) {
+			return req, nil
+		})
+	}
+	decFn1 := createDecFn()
+	decFn2 := createDecFn()
+	for i := 0; i < 3; i++ {
+		_, err := decFn1("a")
+		checkErr(err, t)
+	}
+	if _, err := decFn2("a"); err != ErrorBeyondRateLimit {
+		t.Errorf("ErrorBeyondRateLimit is expected, but the actual is %v", err)
+	}
+	_, err := decFn2("b")
+	checkErr(err, t)
+	checkCnt(int(store.counters["rate_limit/a"].count), 3, t)
+}
@@ -0,0 +1,123 @@
+package service_decorators
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// the default eviction settings of the keyed decorators,
+// which keep the memory bounded when the keys are unbounded (e.g. the client ids)
+const (
+	defaultMaxKeys        = 10000
+	defaultKeyIdleTimeout = time.Minute * 10
+)
+
+// idleCache is the cache whose entries are evicted when they are idle
+// beyond the idle timeout or when the number of the entries is beyond the max entries
+// (the least recently used one is evicted).
+// 0 means no limit for both idleTimeout and maxEntries.
+type idleCache[V any] struct {
+	lock        sync.Mutex
+	maxEntries  int
+	idleTimeout time.Duration
+	entries     map[string]*list.Element
+	// the most recently used entry is at the front
+	lru *list.List
+}
+
+type idleCacheEntry[V any] struct {
+	key        string
+	value      V
+	lastAccess time.Time
+}
+
+func createIdleCache[V any](maxEntries int, idleTimeout time.Duration) *idleCache[V] {
+	return &idleCache[V]{
+		maxEntries:  maxEntries,
+		idleTimeout: idleTimeout,
+		entries:     map[string]*list.Element{},
+		lru:         list.New(),
+	}
+}
+
+// getOrCreate is to get the value of the key,
+// the value is created by the create function when it is not in the cache.
+// The create function is called without holding the lock, so the slow creation doesn't block
+// the other keys. When the key is created by the concurrent requests, only one value is kept.
+func (cache *idleCache[V]) getOrCreate(key string, create func() V) V {
+	if value, ok := cache.touch(key); ok {
+		return value
+	}
+	value := create()
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	now := time.Now()
+	if value, ok := cache.touchLocked(key, now); ok {
+		return value
+	}
+	entry := &idleCacheEntry[V]{key, value, now}
+	cache.entries[key] = cache.lru.PushFront(entry)
+	if cache.maxEntries > 0 && cache.lru.Len() > cache.maxEntries {
+		cache.remove(cache.lru.Back())
+	}
+	return entry.value
+}
+
+// touch is to get the value of the key and refresh its last access time
+func (cache *idleCache[V]) touch(key string) (V, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	now := time.Now()
+	cache.evictIdleEntries(now)
+	return cache.touchLocked(key, now)
+}
+
+func (cache *idleCache[V]) touchLocked(key string, now time.Time) (V, bool) {
+	if elem, ok := cache.entries[key]; ok {
+		entry := elem.Value.(*idleCacheEntry[V])
+		entry.lastAccess = now
+		cache.lru.MoveToFront(elem)
+		return entry.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// get is to get the value of the key without refreshing its last access time
+func (cache *idleCache[V]) get(key string) (V, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.evictIdleEntries(time.Now())
+	if elem, ok := cache.entries[key]; ok {
+		return elem.Value.(*idleCacheEntry[V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (cache *idleCache[V]) len() int {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.evictIdleEntries(time.Now())
+	return cache.lru.Len()
+}
+
+// evictIdleEntries is to remove the idle entries from the back of the LRU list,
+// whose order is the same as the order of the last access time.
+func (cache *idleCache[V]) evictIdleEntries(now time.Time) {
+	if cache.idleTimeout <= 0 {
+		return
+	}
+	for elem := cache.lru.Back(); elem != nil; elem = cache.lru.Back() {
+		if now.Sub(elem.Value.(*idleCacheEntry[V]).lastAccess) <= cache.idleTimeout {
+			return
+		}
+		cache.remove(elem)
+	}
+}
+
+func (cache *idleCache[V]) remove(elem *list.Element) {
+	cache.lru.Remove(elem)
+	delete(cache.entries, elem.Value.(*idleCacheEntry[V]).key)
+}
@@ -0,0 +1,48 @@
+package service_decorators
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := createIdleCache[int](2, 0)
+	cntCreation := 0
+	create := func() int {
+		cntCreation++
+		return cntCreation
+	}
+	cache.getOrCreate("a", create)
+	cache.getOrCreate("b", create)
+	cache.getOrCreate("a", create)
+	cache.getOrCreate("c", create) // "b" is evicted
+	checkCnt(cache.len(), 2, t)
+	checkCnt(cache.getOrCreate("a", create), 1, t)
+	checkCnt(cache.getOrCreate("b", create), 4, t)
+}
+
+func TestIdleCacheEvictsIdleEntries(t *testing.T) {
+	cache := createIdleCache[int](0, time.Millisecond*20)
+	cache.getOrCreate("a", func() int { return 1 })
+	time.Sleep(time.Millisecond * 30)
+	cache.getOrCreate("b", func() int { return 2 })
+	checkCnt(cache.len(), 1, t)
+	checkCnt(cache.getOrCreate("a", func() int { return 3 }), 3, t)
+}
+
+func TestIdleCacheCreatesWithoutHoldingTheLock(t *testing.T) {
+	cache := createIdleCache[int](0, 0)
+	// the creation of a key can access the other keys
+	value := cache.getOrCreate("a", func() int {
+		return cache.getOrCreate("b", func() int { return 2 }) + 1
+	})
+	checkCnt(value, 3, t)
+	checkCnt(cache.len(), 2, t)
+	// the value created concurrently is discarded when the key is already created
+	value = cache.getOrCreate("c", func() int {
+		cache.getOrCreate("c", func() int { return 4 })
+		return 5
+	})
+	checkCnt(value, 4, t)
+	checkCnt(cache.getOrCreate("c", func() int { return 6 }), 4, t)
+}
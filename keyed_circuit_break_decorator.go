@@ -0,0 +1,134 @@
+package service_decorators
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrorKeyedCircuitBreakDecoratorConfig occurred when the configurations are invalid
+var ErrorKeyedCircuitBreakDecoratorConfig = errors.New("keyed circuit breaker configuration is wrong")
+
+// KeyedCircuitBreakDecoratorConfig includes the settings of KeyedCircuitBreakDecorator
+type KeyedCircuitBreakDecoratorConfig struct {
+	// the settings, the state listeners and the override shared by the circuit breakers of all the keys
+	template     *AdvancedCircuitBreakDecorator
+	keyExtractor func(req Request) string
+
+	// the fallback functions of the specific keys,
+	// the fallback function of the template is used for the other keys
+	keyFallbackFunctions map[string]ServiceFallbackFunc
+
+	maxKeys        int
+	keyIdleTimeout time.Duration
+}
+
+// KeyedCircuitBreakDecorator keeps an independent circuit breaker for each key of the requests
+// (e.g. tenant id or backend host), so the failures of a key don't open the circuit breaker of the others.
+// The circuit breaker of a key is created when the first request of the key arrives,
+// and evicted when the key is idle (see WithKeyEviction), the evicted key starts with CLOSED state again.
+type KeyedCircuitBreakDecorator struct {
+	config   *KeyedCircuitBreakDecoratorConfig
+	breakers *idleCache[*advancedCircuitBreaker]
+}
+
+// CreateKeyedCircuitBreakDecoratorConfig is the helper method of creating KeyedCircuitBreakDecorator.
+// template: the circuit breaker settings of each key, including the sliding window, the half-open probes,
+// the fallback function, the state listeners and the override,
+// the state of the template itself is not used
+// keyExtractor: the function to get the key of the request
+// The other settings can be defined by WithXX method chain
+func CreateKeyedCircuitBreakDecoratorConfig(template *AdvancedCircuitBreakDecorator,
+	keyExtractor func(req Request) string) *KeyedCircuitBreakDecoratorConfig {
+	return &KeyedCircuitBreakDecoratorConfig{
+		template:       template,
+		keyExtractor:   keyExtractor,
+		maxKeys:        defaultMaxKeys,
+		keyIdleTimeout: defaultKeyIdleTimeout,
+	}
+}
+
+// WithKeyFallbackFunctions sets the fallback functions of the specific keys,
+// the fallback function of the template is used for the other keys.
+func (config *KeyedCircuitBreakDecoratorConfig) WithKeyFallbackFunctions(
+	fallbackFns map[string]ServiceFallbackFunc) *KeyedCircuitBreakDecoratorConfig {
+	config.keyFallbackFunctions = fallbackFns
+	return config
+}
+
+// WithKeyEviction sets how to evict the circuit breakers of the keys to keep the memory bounded.
+// maxKeys: the max number of the keys, the least recently used one is evicted when beyond it
+// idleTimeout: the circuit breaker is evicted when the key is idle beyond it
+// The default settings are 10000 keys and 10 minutes, 0 means no limit for both of them.
+func (config *KeyedCircuitBreakDecoratorConfig) WithKeyEviction(maxKeys int,
+	idleTimeout time.Duration) *KeyedCircuitBreakDecoratorConfig {
+	config.maxKeys = maxKeys
+	config.keyIdleTimeout = idleTimeout
+	return config
+}
+
+// Build will create KeyedCircuitBreakDecorator with the settings defined by WithXX method chain
+func (config *KeyedCircuitBreakDecoratorConfig) Build() (*KeyedCircuitBreakDecorator, error) {
+	if config.template == nil || config.keyExtractor == nil {
+		return nil, ErrorKeyedCircuitBreakDecoratorConfig
+	}
+	if config.maxKeys < 0 || config.keyIdleTimeout < 0 {
+		return nil, ErrorKeyedCircuitBreakDecoratorConfig
+	}
+	if config.template.HalfOpenMaxProbes < 1 || config.template.HalfOpenSuccessThreshold < 1 {
+		return nil, ErrorKeyedCircuitBreakDecoratorConfig
+	}
+	return &KeyedCircuitBreakDecorator{
+		config:   config,
+		breakers: createIdleCache[*advancedCircuitBreaker](config.maxKeys, config.keyIdleTimeout),
+	}, nil
+}
+
+// breakerOf is to get the circuit breaker of the key, it is created if absent
+func (dec *KeyedCircuitBreakDecorator) breakerOf(key string) *advancedCircuitBreaker {
+	return dec.breakers.getOrCreate(key, func() *advancedCircuitBreaker {
+		return createAdvancedCircuitBreaker(key, dec.config.template.slidingWindow)
+	})
+}
+
+// fallbackOf is to get the fallback function of the key
+func (dec *KeyedCircuitBreakDecorator) fallbackOf(key string) ServiceFallbackFunc {
+	if fallbackFn, ok := dec.config.keyFallbackFunctions[key]; ok {
+		return fallbackFn
+	}
+	return dec.config.template.FallbackFn
+}
+
+// State is to get the current state of the key, the key without the circuit breaker is in CLOSED state.
+func (dec *KeyedCircuitBreakDecorator) State(key string) CircuitBreakerState {
+	return dec.Metrics(key).State
+}
+
+// Metrics is to get the snapshot of the metrics of the key
+func (dec *KeyedCircuitBreakDecorator) Metrics(key string) CircuitBreakerMetrics {
+	if breaker, ok := dec.breakers.get(key); ok {
+		return breaker.getMetrics()
+	}
+	return CircuitBreakerMetrics{State: CircuitBreakerClosed}
+}
+
+// NumOfKeys is to get the number of the keys having the circuit breakers
+func (dec *KeyedCircuitBreakDecorator) NumOfKeys() int {
+	return dec.breakers.len()
+}
+
+// isStatefulCircuitBreaker lets the chain rules check the order of the decorator
+func (dec *KeyedCircuitBreakDecorator) isStatefulCircuitBreaker() {}
+
+// Decorate is to add the circuit break logic to the function
+func (dec *KeyedCircuitBreakDecorator) Decorate(innerFn ServiceFunc) ServiceFunc {
+	return ToServiceFunc(dec.DecorateContext(ToContextServiceFunc(innerFn)))
+}
+
+// DecorateContext is to add the circuit break logic to the context-aware function
+func (dec *KeyedCircuitBreakDecorator) DecorateContext(innerFn ContextServiceFunc) ContextServiceFunc {
+	return func(ctx context.Context, req Request) (Response, error) {
+		key := dec.config.keyExtractor(req)
+		return dec.config.template.invoke(ctx, req, innerFn, dec.breakerOf(key), dec.fallbackOf(key))
+	}
+}
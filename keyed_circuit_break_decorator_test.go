@@ -0,0 +1,130 @@
+package service_decorators
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var errorOfBadTenant = errors.New("bad tenant")
+
+func failBadTenant(req Request) (Response, error) {
+	if tenantOf(req) == "bad" {
+		return nil, errorOfBadTenant
+	}
+	return req, nil
+}
+
+func TestKeyedCircuitBreakersAreIndependent(t *testing.T) {
+	var transitions []CircuitBreakerStateTransition
+	template := CreateAdvancedCircuitBreakDecorator(2, time.Second, time.Second,
+		func(err error) bool { return true }, MockFallbackFn).
+		AddStateListener(func(transition CircuitBreakerStateTransition) {
+			transitions = append(transitions, transition)
+		})
+	dec, err := CreateKeyedCircuitBreakDecoratorConfig(template, tenantOf).Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(failBadTenant)
+	for i := 0; i < 3; i++ {
+		decFn("bad")
+	}
+	if state := dec.State("bad"); state != CircuitBreakerOpen {
+		t.Errorf("The state is expected to be OPEN, but the actual is %v", state)
+	}
+	if ret, err := decFn("good"); err != nil || ret != "good" {
+		t.Errorf("The request of the other key is expected to pass, but the actual is %v, %v", ret, err)
+	}
+	if state := dec.State("good"); state != CircuitBreakerClosed {
+		t.Errorf("The state is expected to be CLOSED, but the actual is %v", state)
+	}
+	if metrics := dec.Metrics("bad"); metrics.NumOfFailures != 2 || metrics.NumOfRejections != 1 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+	if len(transitions) != 1 || transitions[0].Key != "bad" || transitions[0].To != CircuitBreakerOpen {
+		t.Errorf("Unexpected transitions %v", transitions)
+	}
+	// the state of the template itself is not changed
+	if state := template.State(); state != CircuitBreakerClosed {
+		t.Errorf("The state is expected to be CLOSED, but the actual is %v", state)
+	}
+}
+
+func TestKeyedCircuitBreakerWithKeyFallbackFunctions(t *testing.T) {
+	template := CreateAdvancedCircuitBreakDecorator(1, time.Second, time.Second,
+		func(err error) bool { return true }, func(req Request, err error) (Response, error) {
+			return "default", nil
+		})
+	dec, err := CreateKeyedCircuitBreakDecoratorConfig(template, tenantOf).
+		WithKeyFallbackFunctions(map[string]ServiceFallbackFunc{
+			"bad": func(req Request, err error) (Response, error) {
+				return nil, err
+			},
+		}).Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		return nil, errorOfBadTenant
+	})
+	decFn("bad")
+	if _, err := decFn("bad"); err != errorOfBadTenant {
+		t.Errorf("The fallback of the key is expected to return %v, but the actual is %v",
+			errorOfBadTenant, err)
+	}
+	decFn("other")
+	if ret, _ := decFn("other"); ret != "default" {
+		t.Errorf("The fallback of the template is expected, but the actual is %v", ret)
+	}
+}
+
+func TestKeyedCircuitBreakerWithEviction(t *testing.T) {
+	template := CreateAdvancedCircuitBreakDecorator(1, time.Second, time.Hour,
+		func(err error) bool { return true }, MockFallbackFn)
+	dec, err := CreateKeyedCircuitBreakDecoratorConfig(template, tenantOf).
+		WithKeyEviction(100, time.Millisecond*20).Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(failBadTenant)
+	for i := 0; i < 1000; i++ {
+		decFn(strconv.Itoa(i))
+	}
+	decFn("bad")
+	checkCnt(dec.NumOfKeys(), 100, t)
+	if state := dec.State("bad"); state != CircuitBreakerOpen {
+		t.Errorf("The state is expected to be OPEN, but the actual is %v", state)
+	}
+	time.Sleep(time.Millisecond * 30)
+	checkCnt(dec.NumOfKeys(), 0, t)
+	// the circuit breaker of the key starts with CLOSED state after being evicted
+	if state := dec.State("bad"); state != CircuitBreakerClosed {
+		t.Errorf("The state is expected to be CLOSED, but the actual is %v", state)
+	}
+}
+
+func TestKeyedCircuitBreakerWithOverride(t *testing.T) {
+	template := CreateAdvancedCircuitBreakDecorator(1, time.Second, time.Second,
+		func(err error) bool { return true }, func(req Request, err error) (Response, error) {
+			return nil, err
+		})
+	dec, err := CreateKeyedCircuitBreakDecoratorConfig(template, tenantOf).Build()
+	checkErr(err, t)
+	template.SetOverride(ForceOpen)
+	decFn := dec.Decorate(failBadTenant)
+	if _, err := decFn("good"); err != ErrorCircuitBreakerForcedOpen {
+		t.Errorf("ErrorCircuitBreakerForcedOpen is expected, but the actual is %v", err)
+	}
+	checkCnt(int(dec.Metrics("good").NumOfRejections), 1, t)
+}
+
+func TestKeyedCircuitBreakerWithInvalidConfig(t *testing.T) {
+	template := CreateAdvancedCircuitBreakDecorator(1, time.Second, time.Second, nil, MockFallbackFn)
+	if _, err := CreateKeyedCircuitBreakDecoratorConfig(template, nil).Build(); err != ErrorKeyedCircuitBreakDecoratorConfig {
+		t.Errorf("ErrorKeyedCircuitBreakDecoratorConfig is expected, but the actual is %v", err)
+	}
+	if _, err := CreateKeyedCircuitBreakDecoratorConfig(template, tenantOf).
+		WithKeyEviction(-1, 0).Build(); err != ErrorKeyedCircuitBreakDecoratorConfig {
+		t.Errorf("ErrorKeyedCircuitBreakDecoratorConfig is expected, but the actual is %v", err)
+	}
+	template.HalfOpenMaxProbes = 0
+	if _, err := CreateKeyedCircuitBreakDecoratorConfig(template, tenantOf).Build(); err != ErrorKeyedCircuitBreakDecoratorConfig {
+		t.Errorf("ErrorKeyedCircuitBreakDecoratorConfig is expected, but the actual is %v", err)
+	}
+}
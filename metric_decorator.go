@@ -1,6 +1,7 @@
 package service_decorators
 
 import (
+	"context"
 	"time"
 
 	"github.com/easierway/g_met"
@@ -58,9 +59,14 @@ func (config *MetricDecoratorConfig) Build() (*MetricDecorator, error) {
 
 // Decorate is to add the metrics logic to the inner service function
 func (dec *MetricDecorator) Decorate(innerFn ServiceFunc) ServiceFunc {
-	return func(req Request) (Response, error) {
+	return ToServiceFunc(dec.DecorateContext(ToContextServiceFunc(innerFn)))
+}
+
+// DecorateContext is to add the metrics logic to the inner context-aware service function
+func (dec *MetricDecorator) DecorateContext(innerFn ContextServiceFunc) ContextServiceFunc {
+	return func(ctx context.Context, req Request) (Response, error) {
 		startT := time.Now()
-		resp, err := innerFn(req)
+		resp, err := innerFn(ctx, req)
 		timeSpent := time.Since(startT)
 		mItems := make([]g_met.MetricItem, 0, 3)
 
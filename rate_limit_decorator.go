@@ -1,10 +1,11 @@
 package service_decorators
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"sync/atomic"
 	"time"
-
-	"golang.org/x/time/rate"
 )
 
 // ErrorRateLimitDecoratorConfig occurred when the configurations are invalid
@@ -13,44 +14,482 @@ var ErrorRateLimitDecoratorConfig = errors.New("rate limit configuration is wron
 // ErrorBeyondRateLimit occurred when current request rate is beyond the limit
 var ErrorBeyondRateLimit = errors.New("current request rate is beyond the limit")
 
+// RateLimitConfig is the rate limit configuration stored in ConfigStorage.
+type RateLimitConfig struct {
+	Interval        int `json:"Interval"`        //The interval (milliseconds) of the rate, Rate = NumOfRequests / Interval
+	NumOfRequests   int `json:"NumOfRequests"`   //The number of the requests in the interval
+	TokenBucketSize int `json:"TokenBucketSize"` //The max number of the tokens in the bucket, which allows the burst requests
+}
+
+type rateLimitSettings struct {
+	interval        time.Duration
+	numOfRequests   int
+	tokenBucketSize int
+}
+
 // RateLimitDecorator provides the rate limit control
 // RateLimitDecoratorConfig is the rate limit Configurations
 // Rate = NumOfRequests / Interval
+// The rate limit algorithm is the token bucket by default, which can be changed by WithAlgorithm.
 type RateLimitDecorator struct {
-	interval      time.Duration
-	numOfRequests int
-	limiter       *rate.Limiter
+	config        *RateLimitDecoratorConfig
+	settings      atomic.Value
+	limiter       RateLimiter
+	keyOverrides  map[string]*rateLimitSettings
+	keyedLimiters *idleCache[*keyedRateLimiter]
+}
+
+// keyedRateLimiter is the rate limiter of a key.
+// The settings of the key without the override follow the decorator's settings.
+type keyedRateLimiter struct {
+	limiter         RateLimiter
+	appliedSettings atomic.Value
+	isOverridden    bool
+}
+
+func (keyedLimiter *keyedRateLimiter) syncSettings(settings *rateLimitSettings) {
+	if keyedLimiter.isOverridden ||
+		keyedLimiter.appliedSettings.Load().(*rateLimitSettings) == settings {
+		return
+	}
+	settings.applyTo(keyedLimiter.limiter)
+	keyedLimiter.appliedSettings.Store(settings)
+}
+
+func createRateLimitSettings(interval time.Duration, numOfReqs int,
+	tokenBucketSize int) (*rateLimitSettings, error) {
+	if interval <= 0 || numOfReqs <= 0 || tokenBucketSize < 0 {
+		return nil, ErrorRateLimitDecoratorConfig
+	}
+	return &rateLimitSettings{interval, numOfReqs, tokenBucketSize}, nil
+}
+
+func (settings *rateLimitSettings) createLimiter(factory RateLimiterFactory, key string) RateLimiter {
+	return factory(key, settings.interval, settings.numOfRequests, settings.tokenBucketSize)
+}
+
+func (settings *rateLimitSettings) applyTo(limiter RateLimiter) {
+	limiter.SetRate(settings.interval, settings.numOfRequests, settings.tokenBucketSize)
+}
+
+// RateLimitWaitMode decides how to process the request when the token is not available
+type RateLimitWaitMode int
+
+const (
+	// RejectWhenBeyondRateLimit is to reject the request with ErrorBeyondRateLimit at once
+	RejectWhenBeyondRateLimit RateLimitWaitMode = iota
+	// WaitForToken is to wait until the token is available or the context is done.
+	// The request would be rejected early when the token can't be available before the context's deadline.
+	WaitForToken
+	// WaitForTokenWithMaxDelay is to wait for the token no longer than the max wait time.
+	// The request would be rejected early when the delay is beyond the max wait time.
+	WaitForTokenWithMaxDelay
+)
+
+// RateLimitDecoratorConfig includes the settings of RateLimitDecorator
+type RateLimitDecoratorConfig struct {
+	interval        time.Duration
+	numOfRequests   int
+	tokenBucketSize int
+
+	waitMode    RateLimitWaitMode
+	maxWaitTime time.Duration
+
+	// if CostFunction is defined, the number of the tokens taken by the request
+	// is decided by it, otherwise each request takes one token
+	costFunction func(req Request) int
+
+	// if FallbackFunction is defined,
+	// it would be called when the request rate is beyond the limit
+	fallbackFunction ServiceFallbackFunc
+
+	rateLimiterFactory RateLimiterFactory
+	clock              Clock
+
+	// if CounterStore is set, the rate is limited across the service instances
+	counterStore     CounterStore
+	counterKeyPrefix string
+
+	// if KeyExtractor is set, the rate is limited by the key of the request
+	keyExtractor   func(req Request) string
+	keyOverrides   map[string]RateLimitConfig
+	maxKeys        int
+	keyIdleTimeout time.Duration
+
+	// if ConfigStorage is set, the rate limit settings
+	// would be read from the storage and refreshed periodically
+	configStorage   ConfigStorage
+	configName      string
+	refreshInterval time.Duration
+}
+
+// CreateRateLimitDecoratorConfig is the helper method of creating RateLimitDecorator.
+// Rate = numOfReqs / interval
+// The other settings can be defined by WithXX method chain
+func CreateRateLimitDecoratorConfig(interval time.Duration, numOfReqs int,
+	tokenBucketSize int) *RateLimitDecoratorConfig {
+	return &RateLimitDecoratorConfig{
+		interval:           interval,
+		numOfRequests:      numOfReqs,
+		tokenBucketSize:    tokenBucketSize,
+		rateLimiterFactory: rateLimiterFactories[TokenBucket],
+		clock:              systemClock{},
+		maxKeys:            defaultMaxKeys,
+		keyIdleTimeout:     defaultKeyIdleTimeout,
+	}
+}
+
+// WithAlgorithm sets the prebuilt rate limit algorithm, the default one is TokenBucket
+func (config *RateLimitDecoratorConfig) WithAlgorithm(algorithm RateLimitAlgorithm) *RateLimitDecoratorConfig {
+	config.rateLimiterFactory = rateLimiterFactories[algorithm]
+	return config
+}
+
+// WithRateLimiterFactory sets the factory of the customized rate limit algorithm
+func (config *RateLimitDecoratorConfig) WithRateLimiterFactory(
+	factory RateLimiterFactory) *RateLimitDecoratorConfig {
+	config.rateLimiterFactory = factory
+	return config
+}
+
+// WithCounterStore is to limit the rate of all the service instances
+// by the counters shared in the store (see DistributedRateLimiter).
+// The key of the counter is keyPrefix followed by the key of the request (see WithKeyExtractor).
+// The rate limiter created by the algorithm setting (see WithAlgorithm) is used locally
+// when the counter store is unavailable. Each instance allows the full rate by its local limiter,
+// so the total rate could be beyond the setting during the store outage.
+// The token bucket size is ignored by the counter store.
+func (config *RateLimitDecoratorConfig) WithCounterStore(store CounterStore,
+	keyPrefix string) *RateLimitDecoratorConfig {
+	config.counterStore = store
+	config.counterKeyPrefix = keyPrefix
+	return config
+}
+
+// limiterFactory is to get the factory of the rate limiters
+func (config *RateLimitDecoratorConfig) limiterFactory() RateLimiterFactory {
+	if config.counterStore == nil {
+		return config.rateLimiterFactory
+	}
+	return func(key string, interval time.Duration, numOfRequests int, burst int) RateLimiter {
+		return CreateDistributedRateLimiter(config.counterStore, config.counterKeyPrefix+key,
+			interval, numOfRequests, config.rateLimiterFactory(key, interval, numOfRequests, burst))
+	}
+}
+
+// WithClock sets the source of the time, it is for testing
+func (config *RateLimitDecoratorConfig) WithClock(clock Clock) *RateLimitDecoratorConfig {
+	config.clock = clock
+	return config
+}
+
+// WithWaitMode sets how to process the request when the token is not available.
+// The default mode is RejectWhenBeyondRateLimit.
+func (config *RateLimitDecoratorConfig) WithWaitMode(mode RateLimitWaitMode) *RateLimitDecoratorConfig {
+	config.waitMode = mode
+	return config
+}
+
+// WithMaxWaitTime sets the max wait time for WaitForTokenWithMaxDelay mode
+func (config *RateLimitDecoratorConfig) WithMaxWaitTime(maxWaitTime time.Duration) *RateLimitDecoratorConfig {
+	config.maxWaitTime = maxWaitTime
+	return config
+}
+
+// WithFallbackFunction sets the fallback method for the request beyond the rate limit
+func (config *RateLimitDecoratorConfig) WithFallbackFunction(
+	fallbackFn ServiceFallbackFunc) *RateLimitDecoratorConfig {
+	config.fallbackFunction = fallbackFn
+	return config
+}
+
+// WithCostFunction sets the function to decide how many tokens the request takes,
+// so the expensive requests (e.g. bulk queries) take more backend capacity than the cheap ones.
+// The request costing 0 token is never limited,
+// and the request costing more than the token bucket size is always rejected,
+// except LeakyBucket, which allows the costly request when the bucket is empty
+// and delays the following requests by its cost.
+func (config *RateLimitDecoratorConfig) WithCostFunction(
+	costFn func(req Request) int) *RateLimitDecoratorConfig {
+	config.costFunction = costFn
+	return config
+}
+
+// WithKeyExtractor is to limit the rate by the key of the request (e.g. tenant id).
+// Each key has its own token bucket, whose settings are the same as the decorator's ones
+// unless they are overridden by WithKeyOverrides.
+func (config *RateLimitDecoratorConfig) WithKeyExtractor(
+	keyExtractor func(req Request) string) *RateLimitDecoratorConfig {
+	config.keyExtractor = keyExtractor
+	return config
+}
+
+// WithKeyOverrides sets the rate limit settings of the specific keys
+func (config *RateLimitDecoratorConfig) WithKeyOverrides(
+	overrides map[string]RateLimitConfig) *RateLimitDecoratorConfig {
+	config.keyOverrides = overrides
+	return config
+}
+
+// WithKeyEviction sets how to evict the token buckets of the keys to keep the memory bounded.
+// maxKeys: the max number of the keys, the least recently used one is evicted when beyond it
+// idleTimeout: the token bucket is evicted when the key is idle beyond it
+// The default settings are 10000 keys and 10 minutes, 0 means no limit for both of them.
+func (config *RateLimitDecoratorConfig) WithKeyEviction(maxKeys int,
+	idleTimeout time.Duration) *RateLimitDecoratorConfig {
+	config.maxKeys = maxKeys
+	config.keyIdleTimeout = idleTimeout
+	return config
+}
+
+// WithConfigStorage is to read the rate limit settings (RateLimitConfig)
+// from the storage, and refresh them periodically.
+// The settings in the storage override the ones passed to CreateRateLimitDecoratorConfig.
+// The invalid configurations would be ignored and the current limits are kept.
+// configStore: the storage is used to store the rate limit configurations
+// configName: the config name in the storage
+// refreshInterval: the interval of reloading the configurations, 0 means no reloading
+func (config *RateLimitDecoratorConfig) WithConfigStorage(configStorage ConfigStorage,
+	configName string, refreshInterval time.Duration) *RateLimitDecoratorConfig {
+	config.configStorage = configStorage
+	config.configName = configName
+	config.refreshInterval = refreshInterval
+	return config
+}
+
+// Build will create RateLimitDecorator with the settings defined by WithXX method chain
+func (config *RateLimitDecoratorConfig) Build() (*RateLimitDecorator, error) {
+	var (
+		settings *rateLimitSettings
+		err      error
+	)
+	if config.configStorage != nil {
+		settings, err = getRateLimitSettingsFromStorage(config.configStorage, config.configName)
+	} else {
+		settings, err = createRateLimitSettings(config.interval, config.numOfRequests,
+			config.tokenBucketSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if config.waitMode < RejectWhenBeyondRateLimit || config.waitMode > WaitForTokenWithMaxDelay ||
+		(config.waitMode == WaitForTokenWithMaxDelay && config.maxWaitTime <= 0) {
+		return nil, ErrorRateLimitDecoratorConfig
+	}
+	if config.rateLimiterFactory == nil || config.clock == nil {
+		return nil, ErrorRateLimitDecoratorConfig
+	}
+	if config.maxKeys < 0 || config.keyIdleTimeout < 0 {
+		return nil, ErrorRateLimitDecoratorConfig
+	}
+	keyOverrides := map[string]*rateLimitSettings{}
+	for key, override := range config.keyOverrides {
+		keyOverrides[key], err = createRateLimitSettings(
+			time.Duration(override.Interval)*time.Millisecond,
+			override.NumOfRequests, override.TokenBucketSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dec := &RateLimitDecorator{
+		config:        config,
+		limiter:       settings.createLimiter(config.limiterFactory(), ""),
+		keyOverrides:  keyOverrides,
+		keyedLimiters: createIdleCache[*keyedRateLimiter](config.maxKeys, config.keyIdleTimeout),
+	}
+	dec.settings.Store(settings)
+	if config.configStorage != nil {
+		go dec.refreshConfig(config.refreshInterval, config.configStorage, config.configName)
+	}
+	return dec, nil
 }
 
 // CreateRateLimitDecorator is to create a RateLimitDecorator
 func CreateRateLimitDecorator(interval time.Duration, numOfReqs int, tokenBucketSize int) (*RateLimitDecorator, error) {
-	if interval == 0 || numOfReqs <= 0 {
-		return nil, ErrorRateLimitDecoratorConfig
+	return CreateRateLimitDecoratorConfig(interval, numOfReqs, tokenBucketSize).Build()
+}
 
+func getRateLimitSettingsFromStorage(configStorage ConfigStorage,
+	configName string) (*rateLimitSettings, error) {
+	configStr, err := configStorage.Get(configName)
+	if err != nil {
+		return nil, err
 	}
-	qps := 1 / (interval / time.Duration(numOfReqs)).Seconds()
-	l := rate.NewLimiter(rate.Limit(qps), tokenBucketSize)
+	config := RateLimitConfig{}
+	err = json.Unmarshal([]byte(configStr), &config)
+	if err != nil {
+		return nil, err
+	}
+	return createRateLimitSettings(time.Duration(config.Interval)*time.Millisecond,
+		config.NumOfRequests, config.TokenBucketSize)
+}
 
-	return &RateLimitDecorator{
-		interval:      interval,
-		numOfRequests: numOfReqs,
-		limiter:       l,
-	}, nil
+// CreateRateLimitDecoratorWithConfigStorage is to create a RateLimitDecorator,
+// whose settings are read from the storage and refreshed periodically.
+// The invalid configurations would be ignored and the current limits are kept.
+// configStore: the storage is used to store the rate limit configurations (RateLimitConfig)
+// configName: the config name in the storage
+// refreshInterval: the interval of reloading the configurations, 0 means no reloading
+func CreateRateLimitDecoratorWithConfigStorage(configStorage ConfigStorage, configName string,
+	refreshInterval time.Duration) (*RateLimitDecorator, error) {
+	return CreateRateLimitDecoratorConfig(0, 0, 0).
+		WithConfigStorage(configStorage, configName, refreshInterval).
+		Build()
+}
+
+func (dec *RateLimitDecorator) loadSettings() *rateLimitSettings {
+	return dec.settings.Load().(*rateLimitSettings)
+}
+
+func (dec *RateLimitDecorator) updateSettings(settings *rateLimitSettings) {
+	if *settings == *dec.loadSettings() {
+		return
+	}
+	settings.applyTo(dec.limiter)
+	dec.settings.Store(settings)
+}
+
+func (dec *RateLimitDecorator) refreshConfig(
+	refreshInterval time.Duration, configStorage ConfigStorage,
+	configName string) {
+	if refreshInterval <= 0 {
+		return
+	}
+	for _ = range time.Tick(refreshInterval) {
+		updatedSettings, err := getRateLimitSettingsFromStorage(configStorage, configName)
+		if err != nil {
+			continue
+		}
+		dec.updateSettings(updatedSettings)
+	}
 }
 
 func (dec *RateLimitDecorator) tryToGetToken() bool {
-	return dec.limiter.Allow()
+	ok, _ := dec.limiter.TryAcquire(dec.config.clock.Now(), 1)
+	return ok
+}
+
+// limiterOf is to get the rate limiter of the request
+func (dec *RateLimitDecorator) limiterOf(req Request) RateLimiter {
+	if dec.config.keyExtractor == nil {
+		return dec.limiter
+	}
+	key := dec.config.keyExtractor(req)
+	settings := dec.loadSettings()
+	keyedLimiter := dec.keyedLimiters.getOrCreate(key, func() *keyedRateLimiter {
+		keyedLimiter := &keyedRateLimiter{}
+		keySettings := settings
+		if override, ok := dec.keyOverrides[key]; ok {
+			keySettings = override
+			keyedLimiter.isOverridden = true
+		}
+		keyedLimiter.limiter = keySettings.createLimiter(dec.config.limiterFactory(), key)
+		keyedLimiter.appliedSettings.Store(keySettings)
+		return keyedLimiter
+	})
+	keyedLimiter.syncSettings(settings)
+	return keyedLimiter.limiter
+}
+
+// waitForTokens is to wait until n tokens are taken.
+// ErrorBeyondRateLimit is returned without waiting when the delay is beyond
+// the max wait time or the context's deadline.
+func waitForTokens(ctx context.Context, clock Clock, limiter RateLimiter,
+	n int, maxWaitTime time.Duration) error {
+	if reservable, ok := limiter.(ReservableRateLimiter); ok {
+		return waitForReservation(ctx, clock, reservable, n, maxWaitTime)
+	}
+	start := clock.Now()
+	deadline, hasDeadline := ctx.Deadline()
+	for {
+		now := clock.Now()
+		ok, delay := limiter.TryAcquire(now, n)
+		if ok {
+			return nil
+		}
+		if delay < 0 ||
+			(maxWaitTime > 0 && now.Add(delay).Sub(start) > maxWaitTime) ||
+			(hasDeadline && now.Add(delay).After(deadline)) {
+			return ErrorBeyondRateLimit
+		}
+		if err := clock.Sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// waitForReservation is to reserve n tokens and wait until they can be used.
+// The reservation is canceled when the context is done during waiting.
+func waitForReservation(ctx context.Context, clock Clock, limiter ReservableRateLimiter,
+	n int, maxWaitTime time.Duration) error {
+	now := clock.Now()
+	maxDelay := time.Duration(-1)
+	if maxWaitTime > 0 {
+		maxDelay = maxWaitTime
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		untilDeadline := deadline.Sub(now)
+		if untilDeadline < 0 {
+			untilDeadline = 0
+		}
+		if maxDelay < 0 || untilDeadline < maxDelay {
+			maxDelay = untilDeadline
+		}
+	}
+	delay, cancel, ok := limiter.Reserve(now, n, maxDelay)
+	if !ok {
+		return ErrorBeyondRateLimit
+	}
+	if delay <= 0 {
+		return nil
+	}
+	if err := clock.Sleep(ctx, delay); err != nil {
+		cancel(clock.Now())
+		return err
+	}
+	return nil
+}
+
+func (dec *RateLimitDecorator) costOf(req Request) int {
+	if dec.config.costFunction == nil {
+		return 1
+	}
+	return dec.config.costFunction(req)
+}
+
+func (dec *RateLimitDecorator) acquireToken(ctx context.Context, req Request) error {
+	cost := dec.costOf(req)
+	if cost <= 0 {
+		return nil
+	}
+	limiter := dec.limiterOf(req)
+	switch dec.config.waitMode {
+	case WaitForToken:
+		return waitForTokens(ctx, dec.config.clock, limiter, cost, 0)
+	case WaitForTokenWithMaxDelay:
+		return waitForTokens(ctx, dec.config.clock, limiter, cost, dec.config.maxWaitTime)
+	default:
+		if ok, _ := limiter.TryAcquire(dec.config.clock.Now(), cost); !ok {
+			return ErrorBeyondRateLimit
+		}
+		return nil
+	}
 }
 
 // Decorate function is to add request rate limit logic to the function
 func (dec *RateLimitDecorator) Decorate(innerFn ServiceFunc) ServiceFunc {
-	return func(req Request) (Response, error) {
-		if dec.numOfRequests > 0 {
-			if !dec.tryToGetToken() {
-				return nil, ErrorBeyondRateLimit
-			}
+	return ToServiceFunc(dec.DecorateContext(ToContextServiceFunc(innerFn)))
+}
 
+// DecorateContext function is to add request rate limit logic to the context-aware function
+func (dec *RateLimitDecorator) DecorateContext(innerFn ContextServiceFunc) ContextServiceFunc {
+	return func(ctx context.Context, req Request) (Response, error) {
+		if err := dec.acquireToken(ctx, req); err != nil {
+			if err == ErrorBeyondRateLimit && dec.config.fallbackFunction != nil {
+				return dec.config.fallbackFunction(req, err)
+			}
+			return nil, err
 		}
-		return innerFn(req)
+		return innerFn(ctx, req)
 	}
 }
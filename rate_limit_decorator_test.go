@@ -1,6 +1,10 @@
 package service_decorators
 
 import (
+	"context"
+	"runtime"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -61,18 +65,312 @@ func TestRateLimitDecoratorInRateLimitCase(t *testing.T) {
 func TestTokenCreationFrequency(t *testing.T) {
 	dec, err := CreateRateLimitDecorator(time.Second*9, 30000, 10)
 	checkErr(err, t)
-	cntToken := 0
+	var cntToken int64
+	stop := make(chan struct{})
 	go func() {
 		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
 			if dec.tryToGetToken() {
-				cntToken++
+				atomic.AddInt64(&cntToken, 1)
 				//	fmt.Println("Timestamp:", int(time.Now().Nanosecond()/1000))
 			}
+			runtime.Gosched()
 		}
 	}()
 	<-time.After(time.Millisecond * 35)
-	t.Logf("Got %d tokens\n", cntToken)
-	if cntToken > 148 || cntToken < 80 {
+	close(stop)
+	got := atomic.LoadInt64(&cntToken)
+	t.Logf("Got %d tokens\n", got)
+	if got > 148 || got < 80 {
 		t.Error("The frequency control didn't work well!")
 	}
 }
+
+func tokenBucketSizeOf(limiter RateLimiter) int {
+	return limiter.(*TokenBucketRateLimiter).limiter.Burst()
+}
+
+func TestRateLimitDecoratorWithConfigStorage(t *testing.T) {
+	storage := createMemoryConfigStorage(map[string]string{
+		"rate_limit": `{"Interval": 1000, "NumOfRequests": 1, "TokenBucketSize": 1}`,
+	})
+	dec, err := CreateRateLimitDecoratorWithConfigStorage(storage, "rate_limit", time.Millisecond*10)
+	checkErr(err, t)
+	decFn := dec.Decorate(MockServiceFn)
+	countAllowed := func() int {
+		cnt := 0
+		for i := 0; i < 10; i++ {
+			if _, err := decFn(10); err == nil {
+				cnt++
+			}
+		}
+		return cnt
+	}
+	if cnt := countAllowed(); cnt != 1 {
+		t.Errorf("Only 1 request is expected to be allowed, but the actual is %d", cnt)
+	}
+
+	storage.Set("rate_limit", `{"Interval": 1000, "NumOfRequests": 1, "TokenBucketSize": 5}`)
+	time.Sleep(time.Millisecond * 50)
+	settings := dec.loadSettings()
+	if settings.tokenBucketSize != 5 || tokenBucketSizeOf(dec.limiter) != 5 {
+		t.Errorf("The token bucket size is expected to be updated, but the actual is %d",
+			settings.tokenBucketSize)
+	}
+
+	storage.Set("rate_limit", `{"Interval": 0, "NumOfRequests": 1000, "TokenBucketSize": 1000}`)
+	time.Sleep(time.Millisecond * 50)
+	if *dec.loadSettings() != *settings || tokenBucketSizeOf(dec.limiter) != 5 {
+		t.Errorf("The invalid config should be ignored, but the settings are %v", dec.loadSettings())
+	}
+
+	storage.Set("rate_limit", `{"Interval": 1000`)
+	time.Sleep(time.Millisecond * 50)
+	if *dec.loadSettings() != *settings {
+		t.Errorf("The invalid config should be ignored, but the settings are %v", dec.loadSettings())
+	}
+}
+
+func TestRateLimitDecoratorWithInvalidConfigInStorage(t *testing.T) {
+	storage := createMemoryConfigStorage(map[string]string{
+		"rate_limit": `{"Interval": 1000, "NumOfRequests": -1, "TokenBucketSize": 1}`,
+	})
+	_, err := CreateRateLimitDecoratorWithConfigStorage(storage, "rate_limit", 0)
+	if err != ErrorRateLimitDecoratorConfig {
+		t.Errorf("ErrorRateLimitDecoratorConfig is expected, but the actual is %v", err)
+	}
+}
+
+func TestRateLimitDecoratorWaitForToken(t *testing.T) {
+	dec, err := CreateRateLimitDecoratorConfig(time.Millisecond*100, 1, 1).
+		WithWaitMode(WaitForToken).Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(MockServiceFn)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		ret, err := decFn(10)
+		checkInnerFunc(ret, err, t)
+	}
+	if escaped := time.Since(start); escaped < time.Millisecond*180 {
+		t.Errorf("The requests are expected to wait for the tokens, time escaped: %v", escaped)
+	}
+}
+
+func TestRateLimitDecoratorWaitForTokenRejectsBeyondDeadline(t *testing.T) {
+	dec, err := CreateRateLimitDecoratorConfig(time.Second, 1, 1).
+		WithWaitMode(WaitForToken).Build()
+	checkErr(err, t)
+	decFn := dec.DecorateContext(ToContextServiceFunc(MockServiceFn))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	_, err = decFn(ctx, 10)
+	checkErr(err, t)
+	start := time.Now()
+	if _, err = decFn(ctx, 10); err != ErrorBeyondRateLimit {
+		t.Errorf("ErrorBeyondRateLimit is expected, but the actual is %v", err)
+	}
+	if escaped := time.Since(start); escaped > time.Millisecond*50 {
+		t.Errorf("The request is expected to be rejected early, time escaped: %v", escaped)
+	}
+}
+
+func TestRateLimitDecoratorWaitForTokenWithMaxDelay(t *testing.T) {
+	dec, err := CreateRateLimitDecoratorConfig(time.Millisecond*100, 1, 1).
+		WithWaitMode(WaitForTokenWithMaxDelay).
+		WithMaxWaitTime(time.Millisecond * 150).Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(MockServiceFn)
+	ret, err := decFn(10)
+	checkInnerFunc(ret, err, t)
+	// the second request waits for ~100ms
+	ret, err = decFn(10)
+	checkInnerFunc(ret, err, t)
+	// the third and forth requests reserve the tokens concurrently, the later one has to wait for ~200ms
+	respChan := make(chan fnResponse, 2)
+	callFnConcurrently(decFn, 10, 2, respChan, 0)
+	cntRejected := 0
+	for i := 0; i < 2; i++ {
+		if resp := <-respChan; resp.err == ErrorBeyondRateLimit {
+			cntRejected++
+		}
+	}
+	checkCnt(cntRejected, 1, t)
+}
+
+func TestRateLimitDecoratorWaitForTokenCancelsReservation(t *testing.T) {
+	dec, err := CreateRateLimitDecoratorConfig(time.Millisecond*200, 1, 1).
+		WithWaitMode(WaitForToken).Build()
+	checkErr(err, t)
+	decFn := dec.DecorateContext(ToContextServiceFunc(MockServiceFn))
+	_, err = decFn(context.Background(), 10)
+	checkErr(err, t)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*20, cancel)
+	if _, err = decFn(ctx, 10); err != context.Canceled {
+		t.Errorf("context.Canceled is expected, but the actual is %v", err)
+	}
+	// the token reserved by the canceled request is returned
+	start := time.Now()
+	_, err = decFn(context.Background(), 10)
+	checkErr(err, t)
+	if escaped := time.Since(start); escaped > time.Millisecond*300 {
+		t.Errorf("The request is expected to take the returned token, time escaped: %v", escaped)
+	}
+}
+
+func TestRateLimitDecoratorWithInvalidWaitMode(t *testing.T) {
+	_, err := CreateRateLimitDecoratorConfig(time.Second, 1, 1).
+		WithWaitMode(WaitForTokenWithMaxDelay).Build()
+	if err != ErrorRateLimitDecoratorConfig {
+		t.Errorf("ErrorRateLimitDecoratorConfig is expected, but the actual is %v", err)
+	}
+}
+
+func tenantOf(req Request) string {
+	return req.(string)
+}
+
+func TestRateLimitDecoratorByKey(t *testing.T) {
+	dec, err := CreateRateLimitDecoratorConfig(time.Second, 1, 2).
+		WithKeyExtractor(tenantOf).
+		WithKeyOverrides(map[string]RateLimitConfig{
+			"vip": {Interval: 1000, NumOfRequests: 1, TokenBucketSize: 5},
+		}).Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		return req, nil
+	})
+	countAllowed := func(key string) int {
+		cnt := 0
+		for i := 0; i < 10; i++ {
+			if _, err := decFn(key); err == nil {
+				cnt++
+			}
+		}
+		return cnt
+	}
+	checkCnt(countAllowed("noisy"), 2, t)
+	checkCnt(countAllowed("quiet"), 2, t)
+	checkCnt(countAllowed("vip"), 5, t)
+	// the keys are bounded by default
+	if dec.keyedLimiters.maxEntries != defaultMaxKeys || dec.keyedLimiters.idleTimeout != defaultKeyIdleTimeout {
+		t.Errorf("The default eviction settings are expected, but the actual is %d, %v",
+			dec.keyedLimiters.maxEntries, dec.keyedLimiters.idleTimeout)
+	}
+}
+
+func TestRateLimitDecoratorByKeyWithEviction(t *testing.T) {
+	dec, err := CreateRateLimitDecoratorConfig(time.Hour, 1, 1).
+		WithKeyExtractor(tenantOf).
+		WithKeyEviction(100, time.Millisecond*20).Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		return req, nil
+	})
+	for i := 0; i < 1000; i++ {
+		decFn(strconv.Itoa(i))
+	}
+	checkCnt(dec.keyedLimiters.len(), 100, t)
+	if _, err := decFn("999"); err != ErrorBeyondRateLimit {
+		t.Errorf("ErrorBeyondRateLimit is expected, but the actual is %v", err)
+	}
+	time.Sleep(time.Millisecond * 30)
+	checkCnt(dec.keyedLimiters.len(), 0, t)
+	// the token bucket of the key is recreated after being evicted
+	if _, err := decFn("999"); err != nil {
+		t.Errorf("Unexpected error happened %v", err)
+	}
+}
+
+func TestRateLimitDecoratorByKeyWithConfigStorage(t *testing.T) {
+	storage := createMemoryConfigStorage(map[string]string{
+		"rate_limit": `{"Interval": 1000, "NumOfRequests": 1, "TokenBucketSize": 1}`,
+	})
+	dec, err := CreateRateLimitDecoratorConfig(0, 0, 0).
+		WithConfigStorage(storage, "rate_limit", time.Millisecond*10).
+		WithKeyExtractor(tenantOf).Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(func(req Request) (Response, error) {
+		return req, nil
+	})
+	decFn("a")
+	storage.Set("rate_limit", `{"Interval": 1000, "NumOfRequests": 1, "TokenBucketSize": 3}`)
+	time.Sleep(time.Millisecond * 50)
+	if _, err := decFn("a"); err != ErrorBeyondRateLimit {
+		t.Errorf("ErrorBeyondRateLimit is expected, but the actual is %v", err)
+	}
+	if burst := tokenBucketSizeOf(dec.limiterOf("a")); burst != 3 {
+		t.Errorf("The token bucket size of the key is expected to be updated, but the actual is %d", burst)
+	}
+}
+
+func TestRateLimitDecoratorWithFallback(t *testing.T) {
+	var fallbackErr error
+	dec, err := CreateRateLimitDecoratorConfig(time.Second, 1, 1).
+		WithFallbackFunction(func(req Request, err error) (Response, error) {
+			fallbackErr = err
+			return MockFallbackFn(req, err)
+		}).Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(MockServiceFn)
+	ret, err := decFn(10)
+	checkInnerFunc(ret, err, t)
+	ret, err = decFn(10)
+	checkErr(err, t)
+	if ret != -2 || fallbackErr != ErrorBeyondRateLimit {
+		t.Errorf("The fallback function is expected to be invoked with ErrorBeyondRateLimit, "+
+			"but the actual return is %v, %v", ret, fallbackErr)
+	}
+}
+
+func costOfSumRequest(req Request) int {
+	return len(req.(sumRequest))
+}
+
+func TestRateLimitDecoratorWithCostFunction(t *testing.T) {
+	dec, err := CreateRateLimitDecoratorConfig(time.Second, 1, 10).
+		WithCostFunction(costOfSumRequest).Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(sum)
+	_, err = decFn(sumRequest{1, 2, 3, 4, 5, 6})
+	checkErr(err, t)
+	// 4 tokens are left
+	if _, err = decFn(sumRequest{1, 2, 3, 4, 5}); err != ErrorBeyondRateLimit {
+		t.Errorf("ErrorBeyondRateLimit is expected, but the actual is %v", err)
+	}
+	_, err = decFn(sumRequest{1, 2, 3, 4})
+	checkErr(err, t)
+	// the request costing 0 token is never limited
+	_, err = decFn(sumRequest{})
+	checkErr(err, t)
+}
+
+func TestRateLimitDecoratorWaitForTokensWithCostFunction(t *testing.T) {
+	dec, err := CreateRateLimitDecoratorConfig(time.Millisecond*10, 1, 10).
+		WithCostFunction(costOfSumRequest).
+		WithWaitMode(WaitForTokenWithMaxDelay).
+		WithMaxWaitTime(time.Millisecond * 80).Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(sum)
+	_, err = decFn(make(sumRequest, 10))
+	checkErr(err, t)
+	// 5 tokens are needed, it takes ~50ms
+	start := time.Now()
+	_, err = decFn(make(sumRequest, 5))
+	checkErr(err, t)
+	if escaped := time.Since(start); escaped < time.Millisecond*40 {
+		t.Errorf("The request is expected to wait for the tokens, time escaped: %v", escaped)
+	}
+	// beyond the token bucket size
+	if _, err = decFn(make(sumRequest, 11)); err != ErrorBeyondRateLimit {
+		t.Errorf("ErrorBeyondRateLimit is expected, but the actual is %v", err)
+	}
+	// 10 tokens take ~100ms, which is beyond the max wait time
+	if _, err = decFn(make(sumRequest, 10)); err != ErrorBeyondRateLimit {
+		t.Errorf("ErrorBeyondRateLimit is expected, but the actual is %v", err)
+	}
+}
@@ -0,0 +1,438 @@
+package service_decorators
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter is the interface of the rate limit algorithms used by RateLimitDecorator.
+// Rate = numOfRequests / interval. The implementations should be thread-safe.
+type RateLimiter interface {
+	// TryAcquire is to take n tokens at the time now.
+	// When the tokens are not available, it returns false and the duration to wait before retrying.
+	// The negative duration means the n tokens can never be available (e.g. n is beyond the burst).
+	TryAcquire(now time.Time, n int) (bool, time.Duration)
+	// SetRate is to update the rate and the burst at runtime
+	SetRate(interval time.Duration, numOfRequests int, burst int)
+}
+
+// ReservableRateLimiter is the RateLimiter which can reserve the tokens ahead.
+// The waiting requests reserve the tokens before sleeping, so they are served in order
+// instead of competing for the tokens after waking up.
+// RateLimitDecorator uses it for the wait modes when the rate limiter implements it,
+// otherwise the request retries TryAcquire after the returned delay.
+type ReservableRateLimiter interface {
+	RateLimiter
+	// Reserve is to reserve n tokens at the time now when they are available within maxDelay,
+	// the negative maxDelay means no limit.
+	// It returns the delay before the tokens can be used and the function to cancel the reservation.
+	// When the tokens can't be available within maxDelay, nothing is reserved and false is returned.
+	Reserve(now time.Time, n int, maxDelay time.Duration) (time.Duration, func(now time.Time), bool)
+}
+
+// RateLimiterFactory is to create the RateLimiter.
+// key is the key of the request when RateLimitDecorator limits the rate by key, otherwise it is "".
+type RateLimiterFactory func(key string, interval time.Duration, numOfRequests int, burst int) RateLimiter
+
+// RateLimitAlgorithm is the prebuilt rate limit algorithm
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket allows the burst requests up to the token bucket size, it is the default one.
+	TokenBucket RateLimitAlgorithm = iota
+	// FixedWindow allows numOfRequests in each fixed interval, the burst is ignored.
+	FixedWindow
+	// SlidingWindowLog allows numOfRequests in any interval by logging the time of the requests,
+	// the burst is ignored.
+	SlidingWindowLog
+	// SlidingWindowCounter approximates the sliding window by weighting the count of the previous window,
+	// the burst is ignored.
+	SlidingWindowCounter
+	// LeakyBucket paces the requests smoothly at the rate, no burst is allowed.
+	LeakyBucket
+	// GCRA (generic cell rate algorithm) paces the requests at the rate and allows the burst.
+	GCRA
+)
+
+var rateLimiterFactories = map[RateLimitAlgorithm]RateLimiterFactory{
+	TokenBucket: func(key string, interval time.Duration, numOfRequests int, burst int) RateLimiter {
+		return CreateTokenBucketRateLimiter(interval, numOfRequests, burst)
+	},
+	FixedWindow: func(key string, interval time.Duration, numOfRequests int, burst int) RateLimiter {
+		return CreateFixedWindowRateLimiter(interval, numOfRequests)
+	},
+	SlidingWindowLog: func(key string, interval time.Duration, numOfRequests int, burst int) RateLimiter {
+		return CreateSlidingWindowLogRateLimiter(interval, numOfRequests)
+	},
+	SlidingWindowCounter: func(key string, interval time.Duration, numOfRequests int, burst int) RateLimiter {
+		return CreateSlidingWindowCounterRateLimiter(interval, numOfRequests)
+	},
+	LeakyBucket: func(key string, interval time.Duration, numOfRequests int, burst int) RateLimiter {
+		return CreateLeakyBucketRateLimiter(interval, numOfRequests)
+	},
+	GCRA: func(key string, interval time.Duration, numOfRequests int, burst int) RateLimiter {
+		return CreateGCRARateLimiter(interval, numOfRequests, burst)
+	},
+}
+
+// Clock is the source of the time, it can be replaced for testing
+type Clock interface {
+	Now() time.Time
+	// Sleep pauses the current goroutine until d elapses or the context is done
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(ctx context.Context, d time.Duration) error {
+	return sleepWithContext(ctx, d)
+}
+
+// TokenBucketRateLimiter is the token bucket algorithm based on golang.org/x/time/rate
+type TokenBucketRateLimiter struct {
+	limiter *rate.Limiter
+}
+
+func tokenBucketLimit(interval time.Duration, numOfRequests int) rate.Limit {
+	qps := 1 / (interval / time.Duration(numOfRequests)).Seconds()
+	return rate.Limit(qps)
+}
+
+// CreateTokenBucketRateLimiter is to create TokenBucketRateLimiter
+func CreateTokenBucketRateLimiter(interval time.Duration, numOfRequests int, burst int) *TokenBucketRateLimiter {
+	return &TokenBucketRateLimiter{
+		rate.NewLimiter(tokenBucketLimit(interval, numOfRequests), burst),
+	}
+}
+
+// TryAcquire is to take n tokens at the time now
+func (limiter *TokenBucketRateLimiter) TryAcquire(now time.Time, n int) (bool, time.Duration) {
+	if limiter.limiter.AllowN(now, n) {
+		return true, 0
+	}
+	reservation := limiter.limiter.ReserveN(now, n)
+	if !reservation.OK() {
+		return false, -1
+	}
+	delay := reservation.DelayFrom(now)
+	reservation.CancelAt(now)
+	return false, delay
+}
+
+// Reserve is to reserve n tokens at the time now when they are available within maxDelay
+func (limiter *TokenBucketRateLimiter) Reserve(now time.Time, n int,
+	maxDelay time.Duration) (time.Duration, func(now time.Time), bool) {
+	reservation := limiter.limiter.ReserveN(now, n)
+	if !reservation.OK() {
+		return 0, nil, false
+	}
+	delay := reservation.DelayFrom(now)
+	if maxDelay >= 0 && delay > maxDelay {
+		reservation.CancelAt(now)
+		return 0, nil, false
+	}
+	return delay, reservation.CancelAt, true
+}
+
+// SetRate is to update the rate and the burst
+func (limiter *TokenBucketRateLimiter) SetRate(interval time.Duration, numOfRequests int, burst int) {
+	limiter.limiter.SetLimit(tokenBucketLimit(interval, numOfRequests))
+	limiter.limiter.SetBurst(burst)
+}
+
+// FixedWindowRateLimiter allows numOfRequests in each fixed interval
+type FixedWindowRateLimiter struct {
+	lock          sync.Mutex
+	interval      time.Duration
+	numOfRequests int
+	windowStart   time.Time
+	count         int
+}
+
+// CreateFixedWindowRateLimiter is to create FixedWindowRateLimiter
+func CreateFixedWindowRateLimiter(interval time.Duration, numOfRequests int) *FixedWindowRateLimiter {
+	return &FixedWindowRateLimiter{interval: interval, numOfRequests: numOfRequests}
+}
+
+// TryAcquire is to take n tokens at the time now
+func (limiter *FixedWindowRateLimiter) TryAcquire(now time.Time, n int) (bool, time.Duration) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	if n > limiter.numOfRequests {
+		return false, -1
+	}
+	if windowStart := now.Truncate(limiter.interval); windowStart.After(limiter.windowStart) {
+		limiter.windowStart = windowStart
+		limiter.count = 0
+	}
+	if limiter.count+n <= limiter.numOfRequests {
+		limiter.count += n
+		return true, 0
+	}
+	return false, limiter.windowStart.Add(limiter.interval).Sub(now)
+}
+
+// SetRate is to update the rate, the burst is ignored
+func (limiter *FixedWindowRateLimiter) SetRate(interval time.Duration, numOfRequests int, burst int) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	limiter.interval = interval
+	limiter.numOfRequests = numOfRequests
+}
+
+// SlidingWindowLogRateLimiter allows numOfRequests in any interval by logging the time of the requests
+type SlidingWindowLogRateLimiter struct {
+	lock          sync.Mutex
+	interval      time.Duration
+	numOfRequests int
+	logs          []slidingWindowLog
+	count         int
+}
+
+type slidingWindowLog struct {
+	time time.Time
+	n    int
+}
+
+// CreateSlidingWindowLogRateLimiter is to create SlidingWindowLogRateLimiter
+func CreateSlidingWindowLogRateLimiter(interval time.Duration, numOfRequests int) *SlidingWindowLogRateLimiter {
+	return &SlidingWindowLogRateLimiter{interval: interval, numOfRequests: numOfRequests}
+}
+
+// TryAcquire is to take n tokens at the time now
+func (limiter *SlidingWindowLogRateLimiter) TryAcquire(now time.Time, n int) (bool, time.Duration) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	if n > limiter.numOfRequests {
+		return false, -1
+	}
+	expired := 0
+	for ; expired < len(limiter.logs); expired++ {
+		if now.Sub(limiter.logs[expired].time) < limiter.interval {
+			break
+		}
+		limiter.count -= limiter.logs[expired].n
+	}
+	limiter.logs = limiter.logs[expired:]
+	if limiter.count+n <= limiter.numOfRequests {
+		limiter.logs = append(limiter.logs, slidingWindowLog{now, n})
+		limiter.count += n
+		return true, 0
+	}
+	// wait until enough logs are out of the window
+	count := limiter.count
+	for _, log := range limiter.logs {
+		count -= log.n
+		if count+n <= limiter.numOfRequests {
+			return false, log.time.Add(limiter.interval).Sub(now)
+		}
+	}
+	return false, limiter.interval
+}
+
+// SetRate is to update the rate, the burst is ignored
+func (limiter *SlidingWindowLogRateLimiter) SetRate(interval time.Duration, numOfRequests int, burst int) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	limiter.interval = interval
+	limiter.numOfRequests = numOfRequests
+}
+
+// SlidingWindowCounterRateLimiter approximates the sliding window
+// by weighting the count of the previous fixed window with its overlap to the sliding window
+type SlidingWindowCounterRateLimiter struct {
+	lock          sync.Mutex
+	interval      time.Duration
+	numOfRequests int
+	windowStart   time.Time
+	prevCount     int
+	count         int
+}
+
+// CreateSlidingWindowCounterRateLimiter is to create SlidingWindowCounterRateLimiter
+func CreateSlidingWindowCounterRateLimiter(interval time.Duration,
+	numOfRequests int) *SlidingWindowCounterRateLimiter {
+	return &SlidingWindowCounterRateLimiter{interval: interval, numOfRequests: numOfRequests}
+}
+
+// TryAcquire is to take n tokens at the time now
+func (limiter *SlidingWindowCounterRateLimiter) TryAcquire(now time.Time, n int) (bool, time.Duration) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	if n > limiter.numOfRequests {
+		return false, -1
+	}
+	windowStart := now.Truncate(limiter.interval)
+	switch {
+	case windowStart.Equal(limiter.windowStart.Add(limiter.interval)):
+		limiter.prevCount, limiter.count = limiter.count, 0
+		limiter.windowStart = windowStart
+	case windowStart.After(limiter.windowStart):
+		limiter.prevCount, limiter.count = 0, 0
+		limiter.windowStart = windowStart
+	}
+	elapsed := now.Sub(windowStart)
+	prevWeight := float64(limiter.interval-elapsed) / float64(limiter.interval)
+	estimated := float64(limiter.prevCount)*prevWeight + float64(limiter.count)
+	if estimated+float64(n) <= float64(limiter.numOfRequests) {
+		limiter.count += n
+		return true, 0
+	}
+	available := limiter.numOfRequests - limiter.count - n
+	if available < 0 || limiter.prevCount == 0 {
+		return false, limiter.interval - elapsed
+	}
+	// the weight of the previous window has to drop to available / prevCount
+	waitUntil := time.Duration((1 - float64(available)/float64(limiter.prevCount)) *
+		float64(limiter.interval))
+	if waitUntil <= elapsed {
+		waitUntil = elapsed + 1
+	}
+	return false, waitUntil - elapsed
+}
+
+// SetRate is to update the rate, the burst is ignored
+func (limiter *SlidingWindowCounterRateLimiter) SetRate(interval time.Duration, numOfRequests int, burst int) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	limiter.interval = interval
+	limiter.numOfRequests = numOfRequests
+}
+
+// GCRARateLimiter is the generic cell rate algorithm.
+// Each token is emitted at interval / numOfRequests, and at most burst tokens can be taken ahead.
+type GCRARateLimiter struct {
+	lock             sync.Mutex
+	emissionInterval time.Duration
+	burst            int
+	// the theoretical arrival time of the next request
+	tat time.Time
+}
+
+func gcraEmissionInterval(interval time.Duration, numOfRequests int) time.Duration {
+	return interval / time.Duration(numOfRequests)
+}
+
+// CreateGCRARateLimiter is to create GCRARateLimiter
+func CreateGCRARateLimiter(interval time.Duration, numOfRequests int, burst int) *GCRARateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &GCRARateLimiter{
+		emissionInterval: gcraEmissionInterval(interval, numOfRequests),
+		burst:            burst,
+	}
+}
+
+// TryAcquire is to take n tokens at the time now
+func (limiter *GCRARateLimiter) TryAcquire(now time.Time, n int) (bool, time.Duration) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	if n > limiter.burst {
+		return false, -1
+	}
+	tat := limiter.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(time.Duration(n) * limiter.emissionInterval)
+	allowAt := newTat.Add(-time.Duration(limiter.burst) * limiter.emissionInterval)
+	if allowAt.After(now) {
+		return false, allowAt.Sub(now)
+	}
+	limiter.tat = newTat
+	return true, 0
+}
+
+// Reserve is to reserve n tokens at the time now when they are available within maxDelay
+func (limiter *GCRARateLimiter) Reserve(now time.Time, n int,
+	maxDelay time.Duration) (time.Duration, func(now time.Time), bool) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	if n > limiter.burst {
+		return 0, nil, false
+	}
+	tat := limiter.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(time.Duration(n) * limiter.emissionInterval)
+	delay := newTat.Add(-time.Duration(limiter.burst) * limiter.emissionInterval).Sub(now)
+	return limiter.reserve(newTat, n, delay, maxDelay)
+}
+
+// reserve is to move the theoretical arrival time to newTat when the delay is within maxDelay,
+// the canceled reservation moves it back by the n tokens.
+func (limiter *GCRARateLimiter) reserve(newTat time.Time, n int,
+	delay time.Duration, maxDelay time.Duration) (time.Duration, func(now time.Time), bool) {
+	if delay < 0 {
+		delay = 0
+	}
+	if maxDelay >= 0 && delay > maxDelay {
+		return 0, nil, false
+	}
+	limiter.tat = newTat
+	return delay, func(now time.Time) {
+		limiter.lock.Lock()
+		defer limiter.lock.Unlock()
+		limiter.tat = limiter.tat.Add(-time.Duration(n) * limiter.emissionInterval)
+	}, true
+}
+
+// SetRate is to update the rate and the burst
+func (limiter *GCRARateLimiter) SetRate(interval time.Duration, numOfRequests int, burst int) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	limiter.emissionInterval = gcraEmissionInterval(interval, numOfRequests)
+	if burst >= 1 {
+		limiter.burst = burst
+	}
+}
+
+// LeakyBucketRateLimiter paces the requests smoothly at the rate without burst.
+// It is the leaky bucket as a meter, which is the same as GCRA with burst 1 for the single token requests.
+// The request taking n tokens is allowed when the bucket is empty,
+// and the next request has to wait for the n tokens to leak out.
+type LeakyBucketRateLimiter struct {
+	*GCRARateLimiter
+}
+
+// CreateLeakyBucketRateLimiter is to create LeakyBucketRateLimiter
+func CreateLeakyBucketRateLimiter(interval time.Duration, numOfRequests int) *LeakyBucketRateLimiter {
+	return &LeakyBucketRateLimiter{CreateGCRARateLimiter(interval, numOfRequests, 1)}
+}
+
+// TryAcquire is to take n tokens at the time now
+func (limiter *LeakyBucketRateLimiter) TryAcquire(now time.Time, n int) (bool, time.Duration) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	if limiter.tat.After(now) {
+		return false, limiter.tat.Sub(now)
+	}
+	limiter.tat = now.Add(time.Duration(n) * limiter.emissionInterval)
+	return true, 0
+}
+
+// Reserve is to reserve n tokens at the time now when they are available within maxDelay
+func (limiter *LeakyBucketRateLimiter) Reserve(now time.Time, n int,
+	maxDelay time.Duration) (time.Duration, func(now time.Time), bool) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	start := limiter.tat
+	if start.Before(now) {
+		start = now
+	}
+	return limiter.reserve(start.Add(time.Duration(n)*limiter.emissionInterval), n, start.Sub(now), maxDelay)
+}
+
+// SetRate is to update the rate, the burst is ignored
+func (limiter *LeakyBucketRateLimiter) SetRate(interval time.Duration, numOfRequests int, burst int) {
+	limiter.GCRARateLimiter.SetRate(interval, numOfRequests, 1)
+}
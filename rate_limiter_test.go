@@ -0,0 +1,178 @@
+package service_decorators
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testStartTime = time.Unix(1000, 0)
+
+// fakeClock is the Clock for testing, Sleep advances the time without sleeping
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return clock.now
+}
+
+func (clock *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	clock.now = clock.now.Add(d)
+	return nil
+}
+
+func at(ms int) time.Time {
+	return testStartTime.Add(time.Duration(ms) * time.Millisecond)
+}
+
+func checkAcquire(limiter RateLimiter, now time.Time, n int,
+	expectedOk bool, expectedDelay time.Duration, t *testing.T) {
+	t.Helper()
+	ok, delay := limiter.TryAcquire(now, n)
+	if ok != expectedOk || delay != expectedDelay {
+		t.Errorf("Acquiring %d tokens at %v: expected (%v, %v), but the actual is (%v, %v)",
+			n, now.Sub(testStartTime), expectedOk, expectedDelay, ok, delay)
+	}
+}
+
+func TestTokenBucketRateLimiter(t *testing.T) {
+	limiter := CreateTokenBucketRateLimiter(time.Second, 10, 3)
+	checkAcquire(limiter, at(0), 3, true, 0, t)
+	checkAcquire(limiter, at(0), 1, false, time.Millisecond*100, t)
+	checkAcquire(limiter, at(100), 1, true, 0, t)
+	checkAcquire(limiter, at(100), 4, false, -1, t)
+	limiter.SetRate(time.Second, 20, 5)
+	if limiter.limiter.Burst() != 5 || limiter.limiter.Limit() != 20 {
+		t.Errorf("The rate is expected to be updated, but the actual is %v, %d",
+			limiter.limiter.Limit(), limiter.limiter.Burst())
+	}
+}
+
+func TestFixedWindowRateLimiter(t *testing.T) {
+	limiter := CreateFixedWindowRateLimiter(time.Second, 3)
+	checkAcquire(limiter, at(0), 2, true, 0, t)
+	checkAcquire(limiter, at(500), 1, true, 0, t)
+	checkAcquire(limiter, at(900), 1, false, time.Millisecond*100, t)
+	checkAcquire(limiter, at(1000), 3, true, 0, t)
+	checkAcquire(limiter, at(1000), 4, false, -1, t)
+	limiter.SetRate(time.Second, 5, 0)
+	checkAcquire(limiter, at(1999), 2, true, 0, t)
+}
+
+func TestSlidingWindowLogRateLimiter(t *testing.T) {
+	limiter := CreateSlidingWindowLogRateLimiter(time.Second, 3)
+	checkAcquire(limiter, at(0), 1, true, 0, t)
+	checkAcquire(limiter, at(200), 1, true, 0, t)
+	checkAcquire(limiter, at(400), 1, true, 0, t)
+	checkAcquire(limiter, at(600), 1, false, time.Millisecond*400, t)
+	checkAcquire(limiter, at(600), 2, false, time.Millisecond*600, t)
+	checkAcquire(limiter, at(1000), 1, true, 0, t)
+	checkAcquire(limiter, at(1000), 4, false, -1, t)
+}
+
+func TestSlidingWindowCounterRateLimiter(t *testing.T) {
+	limiter := CreateSlidingWindowCounterRateLimiter(time.Second, 10)
+	checkAcquire(limiter, at(0), 10, true, 0, t)
+	checkAcquire(limiter, at(999), 1, false, time.Millisecond, t)
+	// the weight of the previous window is 0.75
+	checkAcquire(limiter, at(1250), 2, true, 0, t)
+	checkAcquire(limiter, at(1250), 1, false, time.Millisecond*50, t)
+	checkAcquire(limiter, at(1301), 1, true, 0, t)
+	// the previous window is not adjacent, its count is dropped
+	checkAcquire(limiter, at(3000), 10, true, 0, t)
+	checkAcquire(limiter, at(3000), 11, false, -1, t)
+}
+
+func TestLeakyBucketRateLimiter(t *testing.T) {
+	limiter := CreateLeakyBucketRateLimiter(time.Second, 10)
+	checkAcquire(limiter, at(0), 1, true, 0, t)
+	checkAcquire(limiter, at(0), 1, false, time.Millisecond*100, t)
+	checkAcquire(limiter, at(50), 1, false, time.Millisecond*50, t)
+	checkAcquire(limiter, at(100), 1, true, 0, t)
+	// the costly request is allowed when the bucket is empty, and it delays the following ones
+	checkAcquire(limiter, at(1000), 3, true, 0, t)
+	checkAcquire(limiter, at(1000), 1, false, time.Millisecond*300, t)
+	checkAcquire(limiter, at(1300), 1, true, 0, t)
+	// the burst is ignored
+	limiter.SetRate(time.Second, 20, 10)
+	checkAcquire(limiter, at(2000), 1, true, 0, t)
+	checkAcquire(limiter, at(2000), 1, false, time.Millisecond*50, t)
+}
+
+func TestGCRARateLimiter(t *testing.T) {
+	limiter := CreateGCRARateLimiter(time.Second, 10, 3)
+	checkAcquire(limiter, at(0), 3, true, 0, t)
+	checkAcquire(limiter, at(0), 1, false, time.Millisecond*100, t)
+	checkAcquire(limiter, at(100), 1, true, 0, t)
+	checkAcquire(limiter, at(100), 2, false, time.Millisecond*200, t)
+	checkAcquire(limiter, at(100), 4, false, -1, t)
+	checkAcquire(limiter, at(1000), 3, true, 0, t)
+}
+
+func checkReserve(limiter ReservableRateLimiter, now time.Time, n int, maxDelay time.Duration,
+	expectedOk bool, expectedDelay time.Duration, t *testing.T) func(now time.Time) {
+	t.Helper()
+	delay, cancel, ok := limiter.Reserve(now, n, maxDelay)
+	if ok != expectedOk || delay != expectedDelay {
+		t.Errorf("Reserving %d tokens at %v: expected (%v, %v), but the actual is (%v, %v)",
+			n, now.Sub(testStartTime), expectedOk, expectedDelay, ok, delay)
+	}
+	return cancel
+}
+
+func TestReservableRateLimiters(t *testing.T) {
+	limiters := map[string]ReservableRateLimiter{
+		"TokenBucket": CreateTokenBucketRateLimiter(time.Second, 10, 1),
+		"GCRA":        CreateGCRARateLimiter(time.Second, 10, 1),
+		"LeakyBucket": CreateLeakyBucketRateLimiter(time.Second, 10),
+	}
+	for name, limiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			checkReserve(limiter, at(0), 1, -1, true, 0, t)
+			checkReserve(limiter, at(0), 1, -1, true, time.Millisecond*100, t)
+			// the waiting requests are served in order
+			cancel := checkReserve(limiter, at(0), 1, -1, true, time.Millisecond*200, t)
+			checkReserve(limiter, at(0), 1, time.Millisecond*250, false, 0, t)
+			cancel(at(50))
+			checkReserve(limiter, at(50), 1, time.Millisecond*150, true, time.Millisecond*150, t)
+			checkAcquire(limiter, at(200), 1, false, time.Millisecond*100, t)
+		})
+	}
+	checkReserve(limiters["GCRA"], at(1000), 2, -1, false, 0, t)
+}
+
+func TestRateLimitDecoratorWithAlgorithmAndClock(t *testing.T) {
+	clock := &fakeClock{now: testStartTime}
+	dec, err := CreateRateLimitDecoratorConfig(time.Second, 10, 5).
+		WithAlgorithm(LeakyBucket).
+		WithClock(clock).
+		WithWaitMode(WaitForToken).Build()
+	checkErr(err, t)
+	decFn := dec.Decorate(MockServiceFn)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		ret, err := decFn(10)
+		checkInnerFunc(ret, err, t)
+	}
+	if escaped := clock.Now().Sub(testStartTime); escaped != time.Millisecond*400 {
+		t.Errorf("The requests are expected to be paced at 100ms, but the time escaped is %v", escaped)
+	}
+	if time.Since(start) > time.Millisecond*100 {
+		t.Error("The fake clock is expected to be used instead of sleeping.")
+	}
+}
+
+func TestRateLimitDecoratorWithInvalidAlgorithm(t *testing.T) {
+	_, err := CreateRateLimitDecoratorConfig(time.Second, 10, 5).
+		WithAlgorithm(RateLimitAlgorithm(100)).Build()
+	if err != ErrorRateLimitDecoratorConfig {
+		t.Errorf("ErrorRateLimitDecoratorConfig is expected, but the actual is %v", err)
+	}
+}
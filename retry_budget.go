@@ -0,0 +1,105 @@
+package service_decorators
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrorRetryBudgetExhausted occurred when the retry is stopped because the retry budget runs out
+var ErrorRetryBudgetExhausted = errors.New("the retry budget is exhausted")
+
+// retryBudgetBuckets is the number of the buckets in the window of RetryBudget
+const retryBudgetBuckets = 10
+
+type retryBudgetBucket struct {
+	index     int64
+	successes int
+	retries   int
+}
+
+// RetryBudget limits the retries by the successful requests in the recent window,
+// so the retries can't multiply the load when the backend is down.
+// The retries allowed in the window are
+// percentage% * successful requests + minRetriesPerSecond * window seconds,
+// e.g. 20% of the successful requests, and at least 10 retries per second for the low traffic.
+// RetryBudget is safe for the concurrent requests, and it can be shared by several RetryDecorators.
+type RetryBudget struct {
+	lock                sync.Mutex
+	percentage          float64
+	minRetriesPerSecond float64
+	window              time.Duration
+	bucketDuration      time.Duration
+	buckets             [retryBudgetBuckets]retryBudgetBucket
+}
+
+// CreateRetryBudget is to create RetryBudget.
+// percentage: the percentage of the successful requests allowed to be retried, e.g. 20 means 20%
+// minRetriesPerSecond: the retries allowed per second regardless of the successful requests
+// window: the time window of counting the successful requests and the retries,
+// it is at least 10ms (1ms for each bucket)
+func CreateRetryBudget(percentage float64, minRetriesPerSecond float64,
+	window time.Duration) (*RetryBudget, error) {
+	if percentage < 0 || minRetriesPerSecond < 0 || window < retryBudgetBuckets*time.Millisecond {
+		return nil, ErrorRetryDecoratorConfig
+	}
+	return &RetryBudget{
+		percentage:          percentage,
+		minRetriesPerSecond: minRetriesPerSecond,
+		window:              window,
+		bucketDuration:      window / retryBudgetBuckets,
+	}, nil
+}
+
+// bucketOf is to get the bucket of the time, the expired bucket is reset.
+func (budget *RetryBudget) bucketOf(now time.Time) *retryBudgetBucket {
+	index := now.UnixNano() / int64(budget.bucketDuration)
+	bucket := &budget.buckets[index%retryBudgetBuckets]
+	if bucket.index != index {
+		*bucket = retryBudgetBucket{index: index}
+	}
+	return bucket
+}
+
+// available is to get the number of the retries allowed now
+func (budget *RetryBudget) available(now time.Time) int {
+	index := now.UnixNano() / int64(budget.bucketDuration)
+	successes, retries := 0, 0
+	for _, bucket := range budget.buckets {
+		if bucket.index <= index && index-bucket.index < retryBudgetBuckets {
+			successes += bucket.successes
+			retries += bucket.retries
+		}
+	}
+	allowed := int(budget.percentage*float64(successes)/100 +
+		budget.minRetriesPerSecond*budget.window.Seconds())
+	if allowed <= retries {
+		return 0
+	}
+	return allowed - retries
+}
+
+// Available is to get the number of the retries allowed now
+func (budget *RetryBudget) Available() int {
+	budget.lock.Lock()
+	defer budget.lock.Unlock()
+	return budget.available(time.Now())
+}
+
+// recordSuccess is to add the successful request to the budget
+func (budget *RetryBudget) recordSuccess(now time.Time) {
+	budget.lock.Lock()
+	defer budget.lock.Unlock()
+	budget.bucketOf(now).successes++
+}
+
+// tryRetry is to take a retry from the budget, it returns false when the budget runs out.
+func (budget *RetryBudget) tryRetry(now time.Time) bool {
+	budget.lock.Lock()
+	defer budget.lock.Unlock()
+	if budget.available(now) <= 0 {
+		return false
+	}
+	budget.bucketOf(now).retries++
+	return true
+}
@@ -0,0 +1,59 @@
+package service_decorators
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBudgetByPercentageOfSuccesses(t *testing.T) {
+	budget, err := CreateRetryBudget(20, 0, time.Second)
+	checkErr(err, t)
+	for i := 0; i < 10; i++ {
+		budget.recordSuccess(at(0))
+	}
+	checkCnt(budget.available(at(0)), 2, t)
+	if !budget.tryRetry(at(100)) || !budget.tryRetry(at(200)) {
+		t.Error("The retries within the budget are expected to be allowed.")
+	}
+	if budget.tryRetry(at(300)) {
+		t.Error("The retry beyond the budget is expected to be rejected.")
+	}
+	// the successes and the retries expire after the window
+	budget.recordSuccess(at(1050))
+	budget.recordSuccess(at(1050))
+	budget.recordSuccess(at(1050))
+	budget.recordSuccess(at(1050))
+	budget.recordSuccess(at(1050))
+	checkCnt(budget.available(at(1150)), 0, t)
+	checkCnt(budget.available(at(1350)), 1, t)
+}
+
+func TestRetryBudgetWithMinRetriesPerSecond(t *testing.T) {
+	budget, err := CreateRetryBudget(20, 2, time.Second*2)
+	checkErr(err, t)
+	for i := 0; i < 4; i++ {
+		if !budget.tryRetry(at(i * 100)) {
+			t.Errorf("The retry %d is expected to be allowed by the min retries per second.", i)
+		}
+	}
+	if budget.tryRetry(at(500)) {
+		t.Error("The retry beyond the budget is expected to be rejected.")
+	}
+	// the retries in the first bucket expire
+	checkCnt(budget.available(at(2100)), 2, t)
+}
+
+func TestRetryBudgetWithInvalidSettings(t *testing.T) {
+	if _, err := CreateRetryBudget(-1, 0, time.Second); err != ErrorRetryDecoratorConfig {
+		t.Errorf("ErrorRetryDecoratorConfig is expected, but the actual is %v", err)
+	}
+	if _, err := CreateRetryBudget(20, 0, 0); err != ErrorRetryDecoratorConfig {
+		t.Errorf("ErrorRetryDecoratorConfig is expected, but the actual is %v", err)
+	}
+	if _, err := CreateRetryBudget(20, 0, time.Millisecond*10-1); err != ErrorRetryDecoratorConfig {
+		t.Errorf("ErrorRetryDecoratorConfig is expected, but the actual is %v", err)
+	}
+	if _, err := CreateRetryBudget(20, 0, time.Millisecond*10); err != nil {
+		t.Errorf("The window of 10ms is expected to be valid, but the actual is %v", err)
+	}
+}
@@ -1,58 +1,292 @@
 package service_decorators
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 )
 
-type retryDecoratorConfig struct {
-	maxRetryTimes     int
-	retryInterval     time.Duration
-	intervalIncrement time.Duration
-	retriableChecker  func(err error) bool
+// ErrorRetryDecoratorConfig occurred when the configurations are invalid
+var ErrorRetryDecoratorConfig = errors.New("retry configuration is wrong")
+
+// ErrorRetryAttemptTimeout occurred when the attempt is beyond the attempt timeout
+var ErrorRetryAttemptTimeout = errors.New("the retry attempt is timeout")
+
+// ErrorRetryMaxElapsedTime occurred when the retries are stopped by the max elapsed time
+var ErrorRetryMaxElapsedTime = errors.New("the retries are beyond the max elapsed time")
+
+// RetryExhaustedError is returned when the retrying gives up,
+// it wraps the errors of all the attempts and the cause of giving up,
+// so they can be checked by errors.Is and errors.As.
+type RetryExhaustedError struct {
+	Attempts   int           // the number of the attempts
+	TotalDelay time.Duration // the total sleep time between the attempts
+	Errors     []error       // the errors of the attempts in order
+	// Cause is why the retrying is stopped before max retry times
+	// (e.g. ErrorRetryBudgetExhausted, ErrorRetryMaxElapsedTime or the context's error),
+	// nil means the max retry times is reached.
+	Cause error
+}
+
+func (err *RetryExhaustedError) Error() string {
+	reason := "the max retry times is reached"
+	if err.Cause != nil {
+		reason = err.Cause.Error()
+	}
+	msg := fmt.Sprintf("retrying gave up after %d attempts (total delay %v), %s",
+		err.Attempts, err.TotalDelay, reason)
+	if len(err.Errors) == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%s, the last error: %v", msg, err.Errors[len(err.Errors)-1])
+}
+
+// Unwrap is to get the errors of the attempts and the cause
+func (err *RetryExhaustedError) Unwrap() []error {
+	if err.Cause == nil {
+		return err.Errors
+	}
+	return append(append([]error{}, err.Errors...), err.Cause)
+}
+
+// RetryHook is called before the next retrying.
+// attempt: the number of the failed attempt, starting from 1
+// err: the error of the failed attempt
+// nextDelay: the sleep time before the next attempt
+type RetryHook func(attempt int, err error, nextDelay time.Duration)
+
+type retryAttemptKey struct{}
+
+// RetryAttemptFromContext is to get the number of the current attempt (starting from 1)
+// in the function decorated by RetryDecorator.DecorateContext.
+func RetryAttemptFromContext(ctx context.Context) (int, bool) {
+	attempt, ok := ctx.Value(retryAttemptKey{}).(int)
+	return attempt, ok
+}
+
+// RetryDecoratorConfig includes the settings of RetryDecorator
+type RetryDecoratorConfig struct {
+	maxRetryTimes    int
+	retriableChecker func(err error) bool
+	backoff          BackoffStrategy
+	budget           *RetryBudget
+	attemptTimeout   time.Duration
+	maxElapsedTime   time.Duration
+	onRetry          RetryHook
 }
 
 // RetryDecorator is to add the retry logic to the decorated method.
 type RetryDecorator struct {
-	config *retryDecoratorConfig
+	config *RetryDecoratorConfig
 }
 
 // CreateRetryDecorator is to create RetryDecorator according to the settings
 // maxRetryTimes : max retry times
 // retryInterval, intervalIncrement : the sleep time before next retrying is  retryInterval + (retry times - 1) * intervalIncrement
 // retriableChecker : the function to check whether the error is retriable
+// The other backoff strategies can be set by CreateRetryDecoratorConfig.
 func CreateRetryDecorator(maxRetryTimes int, retryInterval time.Duration,
 	intervalIncrement time.Duration,
 	retriableChecker func(err error) bool) (*RetryDecorator, error) {
-	if maxRetryTimes <= 0 || retryInterval <= 0 ||
-		intervalIncrement < 0 || retriableChecker == nil {
-		return nil, errors.New("invalid configurations")
+	if retryInterval <= 0 || intervalIncrement < 0 {
+		return nil, ErrorRetryDecoratorConfig
+	}
+	return CreateRetryDecoratorConfig(maxRetryTimes, retriableChecker).
+		WithBackoff(CreateLinearBackoff(retryInterval, intervalIncrement)).
+		Build()
+}
+
+// CreateRetryDecoratorConfig is the helper method of creating RetryDecorator.
+// maxRetryTimes : max retry times
+// retriableChecker : the function to check whether the error is retriable
+// The backoff is FullJitterBackoff from 100ms up to 10s by default.
+// The other settings can be defined by WithXX method chain
+func CreateRetryDecoratorConfig(maxRetryTimes int,
+	retriableChecker func(err error) bool) *RetryDecoratorConfig {
+	return &RetryDecoratorConfig{
+		maxRetryTimes:    maxRetryTimes,
+		retriableChecker: retriableChecker,
+		backoff:          CreateFullJitterBackoff(time.Millisecond*100, time.Second*10),
+	}
+}
+
+// WithBackoff sets the strategy deciding the delay before the next retrying:
+// LinearBackoff, ExponentialBackoff, FullJitterBackoff, EqualJitterBackoff,
+// DecorrelatedJitterBackoff, FixedScheduleBackoff or the customized one.
+// The jitter spreads the retries of the replicas failed at the same time.
+// The prebuilt strategies are validated by Build, e.g. the max delay of the exponential ones
+// should not be less than the base delay.
+func (config *RetryDecoratorConfig) WithBackoff(backoff BackoffStrategy) *RetryDecoratorConfig {
+	config.backoff = backoff
+	return config
+}
+
+// WithRetryBudget is to limit the retries of all the requests through the decorator by the budget,
+// so the retries can't multiply the load when the backend is down.
+// When the budget runs out, the request stops retrying and returns RetryExhaustedError
+// caused by ErrorRetryBudgetExhausted.
+func (config *RetryDecoratorConfig) WithRetryBudget(budget *RetryBudget) *RetryDecoratorConfig {
+	config.budget = budget
+	return config
+}
+
+// WithAttemptTimeout sets the timeout of each attempt.
+// The context passed to the inner function is canceled when the attempt is timeout,
+// and the attempt returns ErrorRetryAttemptTimeout without waiting for the inner function.
+// The timed out attempt is retried when ErrorRetryAttemptTimeout is retriable by the retriable checker.
+func (config *RetryDecoratorConfig) WithAttemptTimeout(timeout time.Duration) *RetryDecoratorConfig {
+	config.attemptTimeout = timeout
+	return config
+}
+
+// WithMaxElapsedTime sets the max time of all the attempts and the sleeps between them.
+// The in-flight attempt is interrupted at the max elapsed time,
+// and the retrying stops when the next delay would be beyond it.
+// In both cases, RetryExhaustedError caused by ErrorRetryMaxElapsedTime is returned.
+func (config *RetryDecoratorConfig) WithMaxElapsedTime(maxElapsedTime time.Duration) *RetryDecoratorConfig {
+	config.maxElapsedTime = maxElapsedTime
+	return config
+}
+
+// WithOnRetry sets the hook called before each retrying, e.g. for logging and metrics.
+// It is called synchronously by the request, so it should return quickly.
+func (config *RetryDecoratorConfig) WithOnRetry(hook RetryHook) *RetryDecoratorConfig {
+	config.onRetry = hook
+	return config
+}
+
+// Build will create RetryDecorator with the settings defined by WithXX method chain
+func (config *RetryDecoratorConfig) Build() (*RetryDecorator, error) {
+	if config.maxRetryTimes <= 0 || config.retriableChecker == nil || config.backoff == nil {
+		return nil, ErrorRetryDecoratorConfig
+	}
+	if config.attemptTimeout < 0 || config.maxElapsedTime < 0 {
+		return nil, ErrorRetryDecoratorConfig
 	}
-	config := retryDecoratorConfig{
-		maxRetryTimes, retryInterval, intervalIncrement, retriableChecker,
+	if backoff, ok := config.backoff.(validatedBackoff); ok && !backoff.isValid() {
+		return nil, ErrorRetryDecoratorConfig
 	}
-	return &RetryDecorator{&config}, nil
+	return &RetryDecorator{config}, nil
 }
 
-// Decorator function is to add the retry logic to the decorated method
+// Decorate function is to add the retry logic to the decorated method
 func (dec *RetryDecorator) Decorate(innerFn ServiceFunc) ServiceFunc {
-	return func(req Request) (Response, error) {
+	return ToServiceFunc(dec.DecorateContext(ToContextServiceFunc(innerFn)))
+}
+
+// DecorateContext function is to add the retry logic to the decorated method.
+// The number of the attempt can be got by RetryAttemptFromContext in the inner function.
+// The sleep between the retries would be interrupted when the context is done,
+// and it is skipped when the context's deadline would be exceeded before the next attempt.
+// The unretriable error is returned as it is,
+// and RetryExhaustedError is returned when the retrying gives up with the retriable errors.
+func (dec *RetryDecorator) DecorateContext(innerFn ContextServiceFunc) ContextServiceFunc {
+	return func(ctx context.Context, req Request) (Response, error) {
 		var (
-			res Response
-			err error
+			res      Response
+			err      error
+			delay    time.Duration
+			deadline time.Time
 		)
-		var interval = dec.config.retryInterval
+		exhausted := &RetryExhaustedError{}
+		if dec.config.maxElapsedTime > 0 {
+			deadline = time.Now().Add(dec.config.maxElapsedTime)
+		}
 		for i := 0; i <= dec.config.maxRetryTimes; i++ {
-			res, err = innerFn(req)
+			if i > 0 {
+				if dec.config.budget != nil && !dec.config.budget.tryRetry(time.Now()) {
+					exhausted.Cause = ErrorRetryBudgetExhausted
+					return res, exhausted
+				}
+				delay = dec.config.backoff.Delay(i, delay)
+				wakeUpTime := time.Now().Add(delay)
+				if !deadline.IsZero() && !wakeUpTime.Before(deadline) {
+					exhausted.Cause = ErrorRetryMaxElapsedTime
+					return res, exhausted
+				}
+				if ctxDeadline, ok := ctx.Deadline(); ok && !wakeUpTime.Before(ctxDeadline) {
+					exhausted.Cause = context.DeadlineExceeded
+					return res, exhausted
+				}
+				if dec.config.onRetry != nil {
+					dec.config.onRetry(i, err, delay)
+				}
+				if sleepErr := sleepWithContext(ctx, delay); sleepErr != nil {
+					exhausted.Cause = sleepErr
+					return res, exhausted
+				}
+				exhausted.TotalDelay += delay
+			}
+			attemptCtx := context.WithValue(ctx, retryAttemptKey{}, i+1)
+			res, err = dec.invokeAttempt(attemptCtx, req, innerFn, deadline)
 			if err == nil {
+				if dec.config.budget != nil {
+					dec.config.budget.recordSuccess(time.Now())
+				}
 				return res, err
 			}
+			exhausted.Attempts++
+			exhausted.Errors = append(exhausted.Errors, err)
+			if err == ErrorRetryMaxElapsedTime {
+				exhausted.Cause = err
+				return res, exhausted
+			}
 			if !dec.config.retriableChecker(err) {
 				return res, err
 			}
-			time.Sleep(interval)
-			interval = interval + dec.config.intervalIncrement
 		}
-		return res, err
+		return res, exhausted
+	}
+}
+
+// invokeAttempt is to invoke the inner function with the attempt timeout and the deadline of the retries
+func (dec *RetryDecorator) invokeAttempt(ctx context.Context, req Request,
+	innerFn ContextServiceFunc, deadline time.Time) (Response, error) {
+	timeout := dec.config.attemptTimeout
+	isLimitedByDeadline := false
+	if !deadline.IsZero() {
+		if remaining := time.Until(deadline); timeout <= 0 || remaining < timeout {
+			timeout = remaining
+			isLimitedByDeadline = true
+		}
+	}
+	if timeout <= 0 && !isLimitedByDeadline {
+		return innerFn(ctx, req)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	output := make(chan serviceFuncResponse, 1)
+	go func() {
+		resp, err := innerFn(attemptCtx, req)
+		output <- serviceFuncResponse{resp: resp, err: err}
+	}()
+	select {
+	case result := <-output:
+		return result.resp, result.err
+	case <-attemptCtx.Done():
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if isLimitedByDeadline {
+			return nil, ErrorRetryMaxElapsedTime
+		}
+		return nil, ErrorRetryAttemptTimeout
+	}
+}
+
+// sleepWithContext pauses the current goroutine for the duration d.
+// It returns the context's error when the context is done before d elapses.
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
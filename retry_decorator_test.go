@@ -1,7 +1,10 @@
 package service_decorators
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -25,7 +28,7 @@ func TestRetryWhenRetriableErrorOccurred(t *testing.T) {
 	checkErr(err, t)
 	decFn := retryDec.Decorate(connectionErrFn)
 	res, decErr := decFn(1)
-	if decErr != ErrorConnection {
+	if !errors.Is(decErr, ErrorConnection) {
 		t.Error("The connection exception is expected.")
 	}
 	if res.(int) != maxRetryTimes+1 {
@@ -101,3 +104,243 @@ func TestCreateDecorateWithInvalidSettings(t *testing.T) {
 	}
 
 }
+
+func TestRetryWithBackoff(t *testing.T) {
+	var invokingTimes []time.Time
+	connectionErrFn := func(req Request) (Response, error) {
+		invokingTimes = append(invokingTimes, time.Now())
+		return nil, ErrorConnection
+	}
+	retryDec, err := CreateRetryDecoratorConfig(2, retriableChecker).
+		WithBackoff(CreateFixedScheduleBackoff(time.Millisecond*10, time.Millisecond*50)).
+		Build()
+	checkErr(err, t)
+	start := time.Now()
+	if _, err := retryDec.Decorate(connectionErrFn)(1); !errors.Is(err, ErrorConnection) {
+		t.Errorf("The connection exception is expected, but the actual is %v", err)
+	}
+	// no sleep after the last retrying
+	if elapsed := time.Since(start); elapsed > time.Millisecond*100 {
+		t.Errorf("The retries are expected to take about 60ms, but the actual is %v", elapsed)
+	}
+	if len(invokingTimes) != 3 {
+		t.Fatalf("The expected execution times is 3, the actual is %d", len(invokingTimes))
+	}
+	if delay := invokingTimes[2].Sub(invokingTimes[1]); delay < time.Millisecond*50 {
+		t.Errorf("The delay of the second retrying is expected to be 50ms, but the actual is %v", delay)
+	}
+}
+
+func TestRetryDecoratorConfigWithInvalidSettings(t *testing.T) {
+	if _, err := CreateRetryDecoratorConfig(3, nil).Build(); err != ErrorRetryDecoratorConfig {
+		t.Errorf("ErrorRetryDecoratorConfig is expected, but the actual is %v", err)
+	}
+	if _, err := CreateRetryDecoratorConfig(3, retriableChecker).WithBackoff(nil).Build(); err != ErrorRetryDecoratorConfig {
+		t.Errorf("ErrorRetryDecoratorConfig is expected, but the actual is %v", err)
+	}
+}
+
+func TestRetryWithRetryBudget(t *testing.T) {
+	budget, err := CreateRetryBudget(20, 0, time.Minute)
+	checkErr(err, t)
+	retryDec, err := CreateRetryDecoratorConfig(3, retriableChecker).
+		WithBackoff(CreateFixedScheduleBackoff(time.Millisecond)).
+		WithRetryBudget(budget).
+		Build()
+	checkErr(err, t)
+	cntExecution := 0
+	isBackendDown := false
+	decFn := retryDec.Decorate(func(req Request) (Response, error) {
+		cntExecution++
+		if isBackendDown {
+			return nil, ErrorConnection
+		}
+		return req, nil
+	})
+	for i := 0; i < 10; i++ {
+		decFn(i)
+	}
+	isBackendDown = true
+	cntExecution = 0
+	_, err = decFn(1)
+	if !errors.Is(err, ErrorRetryBudgetExhausted) {
+		t.Errorf("ErrorRetryBudgetExhausted is expected, but the actual is %v", err)
+	}
+	// 2 retries are allowed by 20% of 10 successful requests
+	checkCnt(cntExecution, 3, t)
+	cntExecution = 0
+	decFn(1)
+	checkCnt(cntExecution, 1, t)
+}
+
+func TestRetryWithAttemptTimeout(t *testing.T) {
+	retryDec, err := CreateRetryDecoratorConfig(2, func(err error) bool {
+		return err == ErrorRetryAttemptTimeout
+	}).
+		WithBackoff(CreateFixedScheduleBackoff(time.Millisecond)).
+		WithAttemptTimeout(time.Millisecond * 20).
+		Build()
+	checkErr(err, t)
+	var cntExecution, cntCanceled int32
+	decFn := retryDec.DecorateContext(func(ctx context.Context, req Request) (Response, error) {
+		if atomic.AddInt32(&cntExecution, 1) < 3 {
+			// the hung attempt
+			<-ctx.Done()
+			atomic.AddInt32(&cntCanceled, 1)
+			return nil, ctx.Err()
+		}
+		return req, nil
+	})
+	ret, err := decFn(context.Background(), 1)
+	checkErr(err, t)
+	if ret != 1 {
+		t.Errorf("The expected response is 1, but the actual is %v", ret)
+	}
+	checkCnt(int(atomic.LoadInt32(&cntExecution)), 3, t)
+	time.Sleep(time.Millisecond * 10)
+	checkCnt(int(atomic.LoadInt32(&cntCanceled)), 2, t)
+}
+
+func TestRetryWithMaxElapsedTime(t *testing.T) {
+	retryDec, err := CreateRetryDecoratorConfig(10, retriableChecker).
+		WithBackoff(CreateFixedScheduleBackoff(time.Millisecond * 30)).
+		WithMaxElapsedTime(time.Millisecond * 100).
+		Build()
+	checkErr(err, t)
+	cntExecution := 0
+	decFn := retryDec.Decorate(func(req Request) (Response, error) {
+		cntExecution++
+		return nil, ErrorConnection
+	})
+	start := time.Now()
+	_, err = decFn(1)
+	if !errors.Is(err, ErrorRetryMaxElapsedTime) {
+		t.Errorf("ErrorRetryMaxElapsedTime is expected, but the actual is %v", err)
+	}
+	// the sleep beyond the max elapsed time is skipped
+	if elapsed := time.Since(start); elapsed >= time.Millisecond*100 {
+		t.Errorf("The retries are expected to stop before the max elapsed time, but the actual is %v", elapsed)
+	}
+	checkCnt(cntExecution, 4, t)
+
+	// the in-flight attempt is interrupted
+	decFn = retryDec.Decorate(func(req Request) (Response, error) {
+		time.Sleep(time.Millisecond * 200)
+		return req, nil
+	})
+	start = time.Now()
+	if _, err = decFn(1); !errors.Is(err, ErrorRetryMaxElapsedTime) {
+		t.Errorf("ErrorRetryMaxElapsedTime is expected, but the actual is %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*150 {
+		t.Errorf("The attempt is expected to be interrupted at the max elapsed time, but the actual is %v", elapsed)
+	}
+}
+
+func TestRetrySkipsSleepBeyondContextDeadline(t *testing.T) {
+	retryDec, err := CreateRetryDecoratorConfig(3, retriableChecker).
+		WithBackoff(CreateFixedScheduleBackoff(time.Second)).
+		Build()
+	checkErr(err, t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	_, err = retryDec.DecorateContext(func(ctx context.Context, req Request) (Response, error) {
+		return nil, ErrorConnection
+	})(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("context.DeadlineExceeded is expected, but the actual is %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*50 {
+		t.Errorf("The sleep is expected to be skipped, but the actual elapsed time is %v", elapsed)
+	}
+}
+
+func TestRetryExhaustedErrorAndHook(t *testing.T) {
+	var retries []string
+	retryDec, err := CreateRetryDecoratorConfig(2, func(err error) bool {
+		return errors.Is(err, ErrorConnection)
+	}).
+		WithBackoff(CreateFixedScheduleBackoff(time.Millisecond, time.Millisecond*2)).
+		WithOnRetry(func(attempt int, err error, nextDelay time.Duration) {
+			retries = append(retries, fmt.Sprintf("%d:%v:%v", attempt, err, nextDelay))
+		}).
+		Build()
+	checkErr(err, t)
+	var attempts []int
+	decFn := retryDec.DecorateContext(func(ctx context.Context, req Request) (Response, error) {
+		attempt, _ := RetryAttemptFromContext(ctx)
+		attempts = append(attempts, attempt)
+		return nil, fmt.Errorf("attempt %d: %w", attempt, ErrorConnection)
+	})
+	_, err = decFn(context.Background(), 1)
+	var exhausted *RetryExhaustedError
+	if !errors.As(err, &exhausted) {
+		t.Fatalf("RetryExhaustedError is expected, but the actual is %v", err)
+	}
+	if !errors.Is(err, ErrorConnection) {
+		t.Errorf("The error is expected to wrap ErrorConnection")
+	}
+	if exhausted.Attempts != 3 || len(exhausted.Errors) != 3 ||
+		exhausted.TotalDelay != time.Millisecond*3 || exhausted.Cause != nil {
+		t.Errorf("Unexpected RetryExhaustedError %+v", exhausted)
+	}
+	if exhausted.Errors[0].Error() != "attempt 1: connection exception" {
+		t.Errorf("The error of the first attempt is expected, but the actual is %v", exhausted.Errors[0])
+	}
+	expectedRetries := []string{"1:attempt 1: connection exception:1ms", "2:attempt 2: connection exception:2ms"}
+	if fmt.Sprint(retries) != fmt.Sprint(expectedRetries) {
+		t.Errorf("The retries are expected to be %v, but the actual is %v", expectedRetries, retries)
+	}
+	if fmt.Sprint(attempts) != "[1 2 3]" {
+		t.Errorf("The attempts are expected to be [1 2 3], but the actual is %v", attempts)
+	}
+	if _, ok := RetryAttemptFromContext(context.Background()); ok {
+		t.Error("No attempt is expected out of RetryDecorator")
+	}
+}
+
+func TestRetryExhaustedErrorWithCause(t *testing.T) {
+	budget, err := CreateRetryBudget(0, 0, time.Second)
+	checkErr(err, t)
+	retryDec, err := CreateRetryDecoratorConfig(2, retriableChecker).
+		WithRetryBudget(budget).
+		Build()
+	checkErr(err, t)
+	_, err = retryDec.Decorate(func(req Request) (Response, error) {
+		return nil, ErrorConnection
+	})(1)
+	var exhausted *RetryExhaustedError
+	if !errors.As(err, &exhausted) || exhausted.Cause != ErrorRetryBudgetExhausted ||
+		exhausted.Attempts != 1 {
+		t.Errorf("RetryExhaustedError caused by the budget is expected, but the actual is %v", err)
+	}
+	if !errors.Is(err, ErrorRetryBudgetExhausted) || !errors.Is(err, ErrorConnection) {
+		t.Errorf("The error is expected to wrap the cause and the attempt error, but the actual is %v", err)
+	}
+}
+
+func TestRetryExhaustedErrorWithoutErrors(t *testing.T) {
+	err := &RetryExhaustedError{Cause: context.Canceled}
+	expected := "retrying gave up after 0 attempts (total delay 0s), context canceled"
+	if err.Error() != expected {
+		t.Errorf("The error message is expected to be %q, but the actual is %q", expected, err.Error())
+	}
+}
+
+func TestRetryDecoratorConfigWithInvalidBackoff(t *testing.T) {
+	invalidBackoffs := []BackoffStrategy{
+		CreateExponentialBackoff(time.Millisecond*10, 0),
+		CreateFullJitterBackoff(time.Millisecond*10, 0),
+		CreateEqualJitterBackoff(0, time.Second),
+		CreateDecorrelatedJitterBackoff(time.Second, time.Millisecond),
+		CreateLinearBackoff(0, time.Millisecond),
+		CreateFixedScheduleBackoff(),
+	}
+	for _, backoff := range invalidBackoffs {
+		if _, err := CreateRetryDecoratorConfig(3, retriableChecker).
+			WithBackoff(backoff).Build(); err != ErrorRetryDecoratorConfig {
+			t.Errorf("ErrorRetryDecoratorConfig is expected for %+v, but the actual is %v", backoff, err)
+		}
+	}
+}
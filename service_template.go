@@ -7,6 +7,8 @@
 // @Created on 2018-6
 package service_decorators
 
+import "context"
+
 // Request is the interface of the service request.
 type Request interface{}
 
@@ -17,6 +19,11 @@ type Response interface{}
 // To leverage the prebuilt decorators, the service function signature should follow it.
 type ServiceFunc func(req Request) (Response, error)
 
+// ContextServiceFunc is the context-aware service function definition.
+// The context carries the deadline, the cancellation signal and
+// the request-scoped values through the chain of the decorators.
+type ContextServiceFunc func(ctx context.Context, req Request) (Response, error)
+
 // ServiceFallbackFunc is the fallback function definition
 type ServiceFallbackFunc func(req Request, err error) (Response, error)
 
@@ -25,3 +32,11 @@ type Decorator interface {
 	// Decorate function is to introduce decorator's the functions
 	Decorate(ServiceFunc) ServiceFunc
 }
+
+// ContextDecorator is the interface of the context-aware decorators.
+// All the prebuilt decorators implement both Decorator and ContextDecorator.
+type ContextDecorator interface {
+	// DecorateContext function is to introduce decorator's the functions
+	// to the context-aware service function
+	DecorateContext(ContextServiceFunc) ContextServiceFunc
+}
@@ -0,0 +1,122 @@
+package service_decorators
+
+import "time"
+
+// SlidingWindowType is the type of the sliding window to evaluate the failure rate and the slow call rate
+type SlidingWindowType int
+
+const (
+	// CountBasedSlidingWindow aggregates the outcomes of the last N calls
+	CountBasedSlidingWindow SlidingWindowType = iota
+	// TimeBasedSlidingWindow aggregates the outcomes of the calls in the last N seconds
+	TimeBasedSlidingWindow
+)
+
+// callOutcome is the outcome of a call recorded in the sliding window
+type callOutcome struct {
+	failed bool
+	slow   bool
+}
+
+type windowStats struct {
+	calls     int
+	failures  int
+	slowCalls int
+}
+
+func (stats *windowStats) add(outcome callOutcome, delta int) {
+	stats.calls += delta
+	if outcome.failed {
+		stats.failures += delta
+	}
+	if outcome.slow {
+		stats.slowCalls += delta
+	}
+}
+
+func (stats *windowStats) merge(other windowStats) {
+	stats.calls += other.calls
+	stats.failures += other.failures
+	stats.slowCalls += other.slowCalls
+}
+
+// slidingWindow is to aggregate the outcomes of the recent calls.
+// The implementations are not thread-safe, they are protected by the lock of the circuit breaker.
+type slidingWindow interface {
+	record(now time.Time, outcome callOutcome)
+	stats(now time.Time) windowStats
+	reset()
+}
+
+func createSlidingWindow(windowType SlidingWindowType, windowSize int) slidingWindow {
+	if windowType == TimeBasedSlidingWindow {
+		return &timeBasedWindow{buckets: make([]timeBucket, windowSize)}
+	}
+	return &countBasedWindow{outcomes: make([]callOutcome, windowSize)}
+}
+
+// countBasedWindow is the ring buffer of the outcomes of the last N calls
+type countBasedWindow struct {
+	outcomes []callOutcome
+	next     int
+	filled   int
+	total    windowStats
+}
+
+func (window *countBasedWindow) record(now time.Time, outcome callOutcome) {
+	if window.filled == len(window.outcomes) {
+		window.total.add(window.outcomes[window.next], -1)
+	} else {
+		window.filled++
+	}
+	window.outcomes[window.next] = outcome
+	window.total.add(outcome, 1)
+	window.next = (window.next + 1) % len(window.outcomes)
+}
+
+func (window *countBasedWindow) stats(now time.Time) windowStats {
+	return window.total
+}
+
+func (window *countBasedWindow) reset() {
+	window.next = 0
+	window.filled = 0
+	window.total = windowStats{}
+}
+
+// timeBucket aggregates the outcomes in a second
+type timeBucket struct {
+	second int64
+	total  windowStats
+}
+
+// timeBasedWindow is the ring of the buckets of the last N seconds
+type timeBasedWindow struct {
+	buckets []timeBucket
+}
+
+func (window *timeBasedWindow) record(now time.Time, outcome callOutcome) {
+	second := now.Unix()
+	bucket := &window.buckets[second%int64(len(window.buckets))]
+	if bucket.second != second {
+		*bucket = timeBucket{second: second}
+	}
+	bucket.total.add(outcome, 1)
+}
+
+func (window *timeBasedWindow) stats(now time.Time) windowStats {
+	second := now.Unix()
+	total := windowStats{}
+	for _, bucket := range window.buckets {
+		if bucket.second <= second && second-bucket.second < int64(len(window.buckets)) {
+			total.merge(bucket.total)
+		}
+	}
+	return total
+}
+
+func (window *timeBasedWindow) reset() {
+	for i := range window.buckets {
+		window.buckets[i] = timeBucket{}
+	}
+}
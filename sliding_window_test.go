@@ -0,0 +1,37 @@
+package service_decorators
+
+import (
+	"testing"
+	"time"
+)
+
+func checkWindowStats(window slidingWindow, now time.Time, expected windowStats, t *testing.T) {
+	t.Helper()
+	if stats := window.stats(now); stats != expected {
+		t.Errorf("The window stats are expected to be %+v, but the actual is %+v", expected, stats)
+	}
+}
+
+func TestCountBasedSlidingWindow(t *testing.T) {
+	window := createSlidingWindow(CountBasedSlidingWindow, 3)
+	window.record(at(0), callOutcome{failed: true})
+	window.record(at(0), callOutcome{slow: true})
+	checkWindowStats(window, at(0), windowStats{calls: 2, failures: 1, slowCalls: 1}, t)
+	window.record(at(0), callOutcome{})
+	window.record(at(0), callOutcome{})
+	checkWindowStats(window, at(0), windowStats{calls: 3, failures: 0, slowCalls: 1}, t)
+	window.reset()
+	checkWindowStats(window, at(0), windowStats{}, t)
+}
+
+func TestTimeBasedSlidingWindow(t *testing.T) {
+	window := createSlidingWindow(TimeBasedSlidingWindow, 2)
+	window.record(at(0), callOutcome{failed: true})
+	window.record(at(500), callOutcome{})
+	window.record(at(1000), callOutcome{slow: true})
+	checkWindowStats(window, at(1500), windowStats{calls: 3, failures: 1, slowCalls: 1}, t)
+	checkWindowStats(window, at(2000), windowStats{calls: 1, failures: 0, slowCalls: 1}, t)
+	window.record(at(2000), callOutcome{failed: true})
+	checkWindowStats(window, at(2500), windowStats{calls: 2, failures: 1, slowCalls: 1}, t)
+	checkWindowStats(window, at(5000), windowStats{}, t)
+}
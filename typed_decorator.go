@@ -0,0 +1,98 @@
+package service_decorators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrorUnexpectedRequestType occurred when the request passed to the typed service function
+// can't be converted to the expected type
+var ErrorUnexpectedRequestType = errors.New("the request type is not expected")
+
+// ErrorUnexpectedResponseType occurred when the response returned by the decorators
+// (e.g. the fallback function) can't be converted to the expected type
+var ErrorUnexpectedResponseType = errors.New("the response type is not expected")
+
+// TypedServiceFunc is the type-safe service function definition.
+type TypedServiceFunc[Req any, Resp any] func(req Req) (Resp, error)
+
+// TypedContextServiceFunc is the type-safe context-aware service function definition.
+type TypedContextServiceFunc[Req any, Resp any] func(ctx context.Context, req Req) (Resp, error)
+
+// TypedFallbackFunc is the type-safe fallback function definition.
+type TypedFallbackFunc[Req any, Resp any] func(req Req, err error) (Resp, error)
+
+// TypedDecorator is to decorate the type-safe service functions with
+// the decorators, so the callers needn't cast Request/Response.
+type TypedDecorator[Req any, Resp any] struct {
+	dec ContextDecorator
+}
+
+// CreateTypedDecorator is to create a TypedDecorator with the decorator.
+// All the prebuilt decorators can be used, e.g.
+//
+//	CreateTypedDecorator[AddRequest, int](retryDec).Decorate(add)
+func CreateTypedDecorator[Req any, Resp any](dec Decorator) *TypedDecorator[Req, Resp] {
+	return &TypedDecorator[Req, Resp]{AsContextDecorator(dec)}
+}
+
+// Decorate is to introduce the decorator's functions to the type-safe service function
+func (typedDec *TypedDecorator[Req, Resp]) Decorate(
+	innerFn TypedServiceFunc[Req, Resp]) TypedServiceFunc[Req, Resp] {
+	decFn := typedDec.DecorateContext(func(ctx context.Context, req Req) (Resp, error) {
+		return innerFn(req)
+	})
+	return func(req Req) (Resp, error) {
+		return decFn(context.Background(), req)
+	}
+}
+
+// DecorateContext is to introduce the decorator's functions to the type-safe context-aware service function
+func (typedDec *TypedDecorator[Req, Resp]) DecorateContext(
+	innerFn TypedContextServiceFunc[Req, Resp]) TypedContextServiceFunc[Req, Resp] {
+	decFn := typedDec.dec.DecorateContext(func(ctx context.Context, req Request) (Response, error) {
+		typedReq, ok := castTo[Req](req)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrorUnexpectedRequestType, req)
+		}
+		return innerFn(ctx, typedReq)
+	})
+	return func(ctx context.Context, req Req) (Resp, error) {
+		resp, err := decFn(ctx, req)
+		return toTypedResponse[Resp](resp, err)
+	}
+}
+
+// ToServiceFallbackFunc adapts the TypedFallbackFunc to ServiceFallbackFunc,
+// which can be set to the decorators' fallback settings.
+func ToServiceFallbackFunc[Req any, Resp any](fn TypedFallbackFunc[Req, Resp]) ServiceFallbackFunc {
+	return func(req Request, err error) (Response, error) {
+		typedReq, ok := castTo[Req](req)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrorUnexpectedRequestType, req)
+		}
+		return fn(typedReq, err)
+	}
+}
+
+func toTypedResponse[Resp any](resp Response, err error) (Resp, error) {
+	typedResp, ok := castTo[Resp](resp)
+	if !ok {
+		if err != nil {
+			return typedResp, err
+		}
+		return typedResp, fmt.Errorf("%w: %T", ErrorUnexpectedResponseType, resp)
+	}
+	return typedResp, err
+}
+
+// castTo converts v to T, nil is converted to the zero value of T.
+func castTo[T any](v interface{}) (T, bool) {
+	if v == nil {
+		var zero T
+		return zero, true
+	}
+	t, ok := v.(T)
+	return t, ok
+}
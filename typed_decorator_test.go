@@ -0,0 +1,81 @@
+package service_decorators
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type addRequest struct {
+	a int
+	b int
+}
+
+func typedAdd(req addRequest) (int, error) {
+	return req.a + req.b, nil
+}
+
+func TestTypedDecoratorHappyCase(t *testing.T) {
+	retryDec, err := CreateRetryDecorator(3, time.Millisecond, 0, retriableChecker)
+	checkErr(err, t)
+	cbDec, err := CreateCircuitBreakDecorator().WithMaxCurrentRequests(10).Build()
+	checkErr(err, t)
+	decFn := CreateTypedDecorator[addRequest, int](cbDec).Decorate(
+		CreateTypedDecorator[addRequest, int](retryDec).Decorate(typedAdd))
+	ret, err := decFn(addRequest{1, 2})
+	checkErr(err, t)
+	if ret != 3 {
+		t.Errorf("The expected return value is %d, but the actual is %d", 3, ret)
+	}
+}
+
+func TestTypedDecoratorWithTypedFallback(t *testing.T) {
+	fallbackFn := func(req addRequest, err error) (int, error) {
+		return -1, nil
+	}
+	cbDec, err := CreateCircuitBreakDecorator().
+		WithTimeout(time.Millisecond * 5).
+		WithTimeoutFallbackFunction(ToServiceFallbackFunc(fallbackFn)).
+		Build()
+	checkErr(err, t)
+	slowFn := func(ctx context.Context, req addRequest) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	decFn := CreateTypedDecorator[addRequest, int](cbDec).DecorateContext(slowFn)
+	ret, err := decFn(context.Background(), addRequest{1, 2})
+	checkErr(err, t)
+	if ret != -1 {
+		t.Errorf("The fallback value is expected, but the actual is %d", ret)
+	}
+}
+
+func TestTypedDecoratorWithUnexpectedResponseType(t *testing.T) {
+	cbDec, err := CreateCircuitBreakDecorator().
+		WithTimeout(time.Millisecond * 5).
+		WithTimeoutFallbackFunction(MockFallbackFn).
+		Build()
+	checkErr(err, t)
+	decFn := CreateTypedDecorator[addRequest, string](cbDec).Decorate(
+		func(req addRequest) (string, error) {
+			time.Sleep(time.Millisecond * 50)
+			return "done", nil
+		})
+	_, err = decFn(addRequest{1, 2})
+	if !errors.Is(err, ErrorUnexpectedResponseType) {
+		t.Errorf("ErrorUnexpectedResponseType is expected, but the actual is %v", err)
+	}
+}
+
+func TestTypedDecoratorWithNilResponse(t *testing.T) {
+	rateLimitDec, err := CreateRateLimitDecorator(time.Second, 1, 1)
+	checkErr(err, t)
+	decFn := CreateTypedDecorator[addRequest, int](rateLimitDec).Decorate(typedAdd)
+	decFn(addRequest{1, 2})
+	ret, err := decFn(addRequest{1, 2})
+	if err != ErrorBeyondRateLimit || ret != 0 {
+		t.Errorf("ErrorBeyondRateLimit and zero value are expected, but the actual are %v, %d",
+			err, ret)
+	}
+}